@@ -0,0 +1,155 @@
+package warlock
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/slok/warlock/engine"
+)
+
+const (
+	// DefaultRetryInterval is the interval between attempts (or the initial
+	// backoff) when not set
+	DefaultRetryInterval = 100 * time.Millisecond
+)
+
+// Strategy is the way a lock already locked is acquired
+type Strategy int
+
+const (
+	// FailFast doesn't retry, the lock fails right away if it's locked
+	FailFast Strategy = iota
+
+	// FixedInterval retries every interval
+	FixedInterval
+
+	// ExponentialBackoff retries doubling the wait after every attempt up to
+	// the max interval
+	ExponentialBackoff
+
+	// WaitRelease retries when the engine notifies the lock was released
+	WaitRelease
+
+	// Queue waits on the engine queue of the key (the engine must be an
+	// engine.Queuer) until the lock is granted, by the lock options priority
+	// and in arrival order within the same priority
+	Queue
+)
+
+// AcquireOptions are the options used to acquire a lock that is locked
+type AcquireOptions struct {
+	// Strategy is the acquisition strategy, fail fast by default
+	Strategy Strategy
+
+	// Interval is the wait between attempts on fixed interval or the initial
+	// wait on exponential backoff, DefaultRetryInterval if not set
+	Interval time.Duration
+
+	// MaxInterval is the max wait between attempts on exponential backoff,
+	// optional
+	MaxInterval time.Duration
+
+	// Jitter is the fraction of the wait randomly added or subtracted,
+	// optional
+	Jitter float64
+
+	// MaxAttempts is the max number of attempts, unlimited if not set
+	MaxAttempts int
+
+	// Timeout is the max time acquiring the lock, unlimited if not set
+	Timeout time.Duration
+
+	// Deadlocks is the wait-for graph where the waits are recorded to detect
+	// deadlocks, the lock owner must be set (locking fails otherwise). Not used
+	// by the queue strategy. Optional
+	Deadlocks *engine.WaitGraph
+}
+
+// retryWait returns the wait before the next attempt after the failed
+// attempt (starting at 1), false if the lock shouldn't be retried anymore
+func (o AcquireOptions) retryWait(attempt int) (time.Duration, bool) {
+	if o.Strategy == FailFast || o.Strategy == Queue || (o.MaxAttempts > 0 && attempt >= o.MaxAttempts) {
+		return 0, false
+	}
+
+	d := o.Interval
+	if d <= 0 {
+		d = DefaultRetryInterval
+	}
+	switch o.Strategy {
+	case WaitRelease:
+		return 0, true
+	case ExponentialBackoff:
+		for i := 1; i < attempt; i++ {
+			d *= 2
+			if o.MaxInterval > 0 && d >= o.MaxInterval {
+				d = o.MaxInterval
+				break
+			}
+		}
+	}
+	if o.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * o.Jitter * float64(d))
+	}
+	return d, true
+}
+
+// LockResult is the result of trying to lock a lock
+type LockResult struct {
+	// Acquired is true if the lock was acquired
+	Acquired bool
+
+	// Holder is the owner holding the lock when it wasn't acquired, empty if
+	// the engine can't tell
+	Holder string
+
+	// TTL is the time left until the holder lock expires if not renewed, zero
+	// if the engine can't tell or the lock doesn't expire
+	TTL time.Duration
+
+	// Token is the fencing token of the acquired lock, or of the holder lock
+	// when it wasn't acquired. Zero if the engine doesn't support fencing
+	Token uint64
+}
+
+// AcquireError is returned when a lock couldn't be acquired, it wraps the
+// error of the last attempt (or the context error if the acquisition was
+// cancelled or timed out)
+type AcquireError struct {
+	// Attempts is the number of attempts
+	Attempts int
+
+	// Holder is the holder of the lock on the last attempt, empty if the
+	// engine can't tell it
+	Holder string
+
+	// Err is the wrapped error
+	Err error
+}
+
+func (e *AcquireError) Error() string {
+	if e.Holder != "" {
+		return fmt.Sprintf("%s after %d attempts (held by %s)", e.Err, e.Attempts, e.Holder)
+	}
+	return fmt.Sprintf("%s after %d attempts", e.Err, e.Attempts)
+}
+
+// Unwrap returns the wrapped error
+func (e *AcquireError) Unwrap() error {
+	return e.Err
+}
+
+// acquireError returns the acquire error with the holder of the lock if the
+// engine can tell it
+func (w *Warlock) acquireError(attempts int, err error) error {
+	ae := &AcquireError{Attempts: attempts, Err: err}
+	if i, ok := w.Engine.(engine.Inspector); ok {
+		// Lock can be cancelled, the holder is asked anyway
+		if holder, herr := i.Holder(context.Background(), w.key()); herr == nil {
+			ae.Holder = holder
+		}
+	}
+	return ae
+}
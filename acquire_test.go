@@ -0,0 +1,186 @@
+package warlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/engine"
+)
+
+func TestAcquireRetryWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    AcquireOptions
+		attempt int
+		expWait time.Duration
+		expOK   bool
+	}{
+		{"fail fast", AcquireOptions{}, 1, 0, false},
+		{"fixed default interval", AcquireOptions{Strategy: FixedInterval}, 3, DefaultRetryInterval, true},
+		{"fixed interval", AcquireOptions{Strategy: FixedInterval, Interval: time.Second}, 1, time.Second, true},
+		{"max attempts", AcquireOptions{Strategy: FixedInterval, MaxAttempts: 3}, 3, 0, false},
+		{"backoff", AcquireOptions{Strategy: ExponentialBackoff, Interval: time.Second}, 3, 4 * time.Second, true},
+		{"max backoff", AcquireOptions{Strategy: ExponentialBackoff, Interval: time.Second, MaxInterval: 3 * time.Second}, 5, 3 * time.Second, true},
+		{"wait release", AcquireOptions{Strategy: WaitRelease}, 10, 0, true},
+	}
+
+	for _, test := range tests {
+		wait, ok := test.opts.retryWait(test.attempt)
+		if wait != test.expWait || ok != test.expOK {
+			t.Errorf("%s: retry should be %s (%t); got %s (%t)", test.name, test.expWait, test.expOK, wait, ok)
+		}
+	}
+}
+
+func TestAcquireJitter(t *testing.T) {
+	opts := AcquireOptions{Strategy: FixedInterval, Interval: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if wait, _ := opts.retryWait(1); wait < 500*time.Millisecond || wait > 1500*time.Millisecond {
+			t.Fatalf("Retry should wait between 0.5s and 1.5s; got %s", wait)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        AcquireOptions
+		release     bool
+		expAttempts int
+		expErr      error
+	}{
+		{"fail fast", AcquireOptions{}, false, 1, ErrLocked},
+		{"max attempts", AcquireOptions{Strategy: FixedInterval, Interval: time.Millisecond, MaxAttempts: 3}, false, 3, ErrLocked},
+		{"timeout", AcquireOptions{Strategy: WaitRelease, Timeout: 20 * time.Millisecond}, false, 1, context.DeadlineExceeded},
+		{"fixed interval", AcquireOptions{Strategy: FixedInterval, Interval: 5 * time.Millisecond}, true, 0, nil},
+		{"backoff", AcquireOptions{Strategy: ExponentialBackoff, Interval: time.Millisecond, Jitter: 0.2}, true, 0, nil},
+		{"wait release", AcquireOptions{Strategy: WaitRelease}, true, 0, nil},
+		{"queue", AcquireOptions{Strategy: Queue}, true, 0, nil},
+		{"queue timeout", AcquireOptions{Strategy: Queue, Timeout: 20 * time.Millisecond}, false, 1, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		e := &engine.Memory{}
+		l1 := Warlock{Key: key, Engine: e, Options: engine.LockOptions{Owner: "owner1"}}
+		l2 := Warlock{Key: key, Engine: e, Acquire: test.opts}
+		if err := l1.Lock(context.Background()); err != nil {
+			t.Fatalf("%s: Lock shouldn't return an error: %v", test.name, err)
+		}
+		if test.release {
+			time.AfterFunc(20*time.Millisecond, func() { l1.Unlock(context.Background()) })
+		}
+
+		err := l2.Lock(context.Background())
+		if test.expErr == nil {
+			if err != nil {
+				t.Errorf("%s: Lock shouldn't return an error: %v", test.name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, test.expErr) {
+			t.Errorf("%s: Lock should return %q; got %v", test.name, test.expErr, err)
+		}
+		var aerr *AcquireError
+		if !errors.As(err, &aerr) {
+			t.Errorf("%s: Lock should return an acquire error; got %T", test.name, err)
+			continue
+		}
+		if aerr.Attempts != test.expAttempts || aerr.Holder != "owner1" {
+			t.Errorf("%s: Lock should fail after %d attempts held by owner1; got %d held by %q", test.name, test.expAttempts, aerr.Attempts, aerr.Holder)
+		}
+	}
+}
+
+func TestAcquireClose(t *testing.T) {
+	for _, strategy := range []Strategy{WaitRelease, Queue} {
+		e := &engine.Memory{}
+		l1 := Warlock{Key: key, Engine: e}
+		l2 := &Warlock{Key: key, Engine: e, Acquire: AcquireOptions{Strategy: strategy}}
+		if err := l1.Lock(context.Background()); err != nil {
+			t.Fatalf("Lock shouldn't return an error: %v", err)
+		}
+
+		time.AfterFunc(10*time.Millisecond, func() { l2.Close(context.Background()) })
+		if err := l2.Lock(context.Background()); !errors.Is(err, ErrClosed) {
+			t.Errorf("Lock should return a closed error when closed while acquiring; got %v", err)
+		}
+		l1.Unlock(context.Background())
+	}
+}
+
+func TestAcquireQueueNotQueuer(t *testing.T) {
+	l := Warlock{Key: key, Engine: newTestEngine(), Acquire: AcquireOptions{Strategy: Queue}}
+	if err := l.Lock(context.Background()); err == nil {
+		t.Errorf("Lock should return an error when the engine can't queue, it didn't")
+	}
+}
+
+func TestAcquireDeadlockWithoutOwner(t *testing.T) {
+	e := &engine.Memory{}
+	l := Warlock{
+		Key:     key,
+		Engine:  e,
+		Acquire: AcquireOptions{Strategy: WaitRelease, Deadlocks: &engine.WaitGraph{Engine: e}},
+	}
+	if err := l.Lock(context.Background()); err == nil {
+		t.Errorf("Lock should return an error detecting deadlocks without owner, it didn't")
+	}
+	if locked, _ := e.Locked(context.Background(), key); locked {
+		t.Errorf("Key shouldn't be locked, it was")
+	}
+}
+
+func TestAcquireDeadlock(t *testing.T) {
+	ctx := context.Background()
+	e := &engine.Memory{}
+	g := &engine.WaitGraph{Engine: e, CheckInterval: 10 * time.Millisecond}
+	lock := func(owner, key string) *Warlock {
+		return &Warlock{
+			Key:     key,
+			Engine:  e,
+			Options: engine.LockOptions{Owner: owner},
+			Acquire: AcquireOptions{Strategy: WaitRelease, Deadlocks: g},
+		}
+	}
+
+	a1, b2 := lock("a", "k1"), lock("b", "k2")
+	if err := a1.Lock(ctx); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := b2.Lock(ctx); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	// a waits for k2 first so b is the youngest waiter
+	a2 := lock("a", "k2")
+	locked := make(chan error, 1)
+	go func() { locked <- a2.Lock(ctx) }()
+	for edges, _ := g.Edges(ctx); len(edges) == 0; edges, _ = g.Edges(ctx) {
+		time.Sleep(time.Millisecond)
+	}
+
+	b1 := lock("b", "k1")
+	err := b1.Lock(ctx)
+	if !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("Lock should return a deadlock error; got %v", err)
+	}
+
+	// The victim releases its locks and the deadlock is broken
+	if err := b2.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Errorf("Lock shouldn't return an error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Lock should be acquired when the deadlock is broken")
+	}
+	if edges, _ := g.Edges(ctx); len(edges) != 0 {
+		t.Errorf("there shouldn't be waiters; got %v", edges)
+	}
+}
@@ -0,0 +1,88 @@
+// Package audit has the events of the lock transitions, so who held which
+// lock when can be recorded, and the sinks that receive them
+package audit
+
+import (
+	"time"
+)
+
+// EventType is the type of a lock transition
+type EventType string
+
+// Event types
+const (
+	// Attempt is a lock attempt
+	Attempt EventType = "attempt"
+
+	// Acquired is a lock acquired
+	Acquired EventType = "acquired"
+
+	// Renewed is a lock renewed by its holder
+	Renewed EventType = "renewed"
+
+	// RenewalFailed is a failed renewal of a held lock
+	RenewalFailed EventType = "renewal_failed"
+
+	// Released is a lock released by its holder
+	Released EventType = "released"
+
+	// Expired is a lock that expired
+	Expired EventType = "expired"
+
+	// Broken is an expired lock of other holder removed
+	Broken EventType = "broken"
+
+	// Lost is a held lock lost (not renewed in time or taken by other owner)
+	Lost EventType = "lost"
+)
+
+// Event is a lock transition
+type Event struct {
+	// Type is the transition
+	Type EventType `json:"type"`
+
+	// Time is when it happened
+	Time time.Time `json:"time"`
+
+	// Engine is the name of the engine
+	Engine string `json:"engine"`
+
+	// Key is the key of the lock
+	Key string `json:"key"`
+
+	// Owner is the holder of the lock, empty if unknown
+	Owner string `json:"owner,omitempty"`
+
+	// Token is the fencing token of the lock, zero if unknown
+	Token uint64 `json:"token,omitempty"`
+
+	// Error is the error of the failed transitions
+	Error string `json:"error,omitempty"`
+}
+
+// Sink receives the events, it must be safe to use concurrently and it
+// shouldn't block as the events are emitted while locking
+type Sink interface {
+	// Emit receives an event
+	Emit(e Event)
+}
+
+// Dummy is a sink that discards the events, used as the default one
+var Dummy Sink = &dummy{}
+
+type dummy struct{}
+
+func (d *dummy) Emit(e Event) {}
+
+// Multi returns a sink that emits the events to all the sinks
+func Multi(sinks ...Sink) Sink {
+	return multi(sinks)
+}
+
+type multi []Sink
+
+func (m multi) Emit(e Event) {
+	for _, s := range m {
+		s.Emit(e)
+	}
+}
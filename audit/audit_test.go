@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/log"
+)
+
+var event = Event{
+	Type:   Acquired,
+	Time:   time.Unix(100, 0).UTC(),
+	Engine: "file",
+	Key:    "k",
+	Owner:  "owner1",
+	Token:  3,
+}
+
+func TestJSONLines(t *testing.T) {
+	b := &bytes.Buffer{}
+	s := NewJSONLines(b)
+	s.Emit(event)
+	failed := Event{Type: RenewalFailed, Time: event.Time, Engine: "file", Key: "k", Error: "wrong"}
+	s.Emit(failed)
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("There should be 2 lines; got %d: %q", len(lines), b.String())
+	}
+	for i, exp := range []Event{event, failed} {
+		var got Event
+		if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+			t.Fatalf("Line should be JSON: %v", err)
+		}
+		if got != exp {
+			t.Errorf("Event should be %+v; got %+v", exp, got)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	b := &bytes.Buffer{}
+	NewLogger(log.NewSlog(slog.New(slog.NewJSONHandler(b, nil)))).Emit(event)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		t.Fatalf("Log entry should be JSON: %v", err)
+	}
+	expected := map[string]interface{}{
+		"level":         "INFO",
+		"msg":           "lock acquired",
+		EventField:      "acquired",
+		log.KeyField:    "k",
+		log.EngineField: "file",
+		OwnerField:      "owner1",
+		TokenField:      float64(3),
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("Wrong log field %s, expected %v; got %v", k, v, entry[k])
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	h := &Hub{}
+	c1, unsubscribe1 := h.Subscribe(1)
+	c2, unsubscribe2 := h.Subscribe(1)
+	defer unsubscribe2()
+
+	// The events that don't fit are missed, the emitter is never blocked
+	s := Multi(Dummy, h)
+	s.Emit(event)
+	s.Emit(Event{Type: Released})
+	for _, c := range []<-chan Event{c1, c2} {
+		if e := <-c; e != event {
+			t.Errorf("Event should be %+v; got %+v", event, e)
+		}
+		select {
+		case e := <-c:
+			t.Errorf("The event that didn't fit should be missed; got %+v", e)
+		default:
+		}
+	}
+
+	unsubscribe1()
+	unsubscribe1()
+	if _, ok := <-c1; ok {
+		t.Errorf("The channel should be closed after unsubscribing, it wasn't")
+	}
+	h.Emit(event)
+	if e := <-c2; e != event {
+		t.Errorf("Event should be %+v; got %+v", event, e)
+	}
+}
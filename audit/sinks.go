@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/slok/warlock/log"
+)
+
+// Field names used on the logged events
+const (
+	EventField = "event"
+	OwnerField = "owner"
+	TokenField = "token"
+	TimeField  = "time"
+)
+
+// JSONLines is a sink that writes the events as JSON lines, like an
+// append-only audit file
+type JSONLines struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLines returns a sink writing the events on w, the write errors are
+// ignored
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w: w}
+}
+
+// Emit satisfies Sink interface
+func (j *JSONLines) Emit(e Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(b)
+}
+
+// logger is a sink that logs the events
+type logger struct {
+	l log.Logger
+}
+
+// NewLogger returns a sink that logs the events at info level, the failed
+// transitions at warn level
+func NewLogger(l log.Logger) Sink {
+	return &logger{l: l}
+}
+
+// Emit satisfies Sink interface
+func (l *logger) Emit(e Event) {
+	fields := []log.Field{
+		log.F(EventField, string(e.Type)),
+		log.F(log.EngineField, e.Engine),
+		log.F(log.KeyField, e.Key),
+		log.F(TimeField, e.Time),
+	}
+	if e.Owner != "" {
+		fields = append(fields, log.F(OwnerField, e.Owner))
+	}
+	if e.Token != 0 {
+		fields = append(fields, log.F(TokenField, e.Token))
+	}
+	if e.Error != "" {
+		fields = append(fields, log.F(log.ErrorField, e.Error))
+		l.l.Warn("lock "+string(e.Type), fields...)
+		return
+	}
+	l.l.Info("lock "+string(e.Type), fields...)
+}
+
+// Hub is a sink that broadcasts the events to its subscribers, the
+// subscribers that don't keep up miss the events that don't fit on their
+// buffer
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Emit satisfies Sink interface
+func (h *Hub) Emit(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.subs {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events from now on with room for
+// buffer events, and the function to unsubscribe that closes the channel
+func (h *Hub) Subscribe(buffer int) (<-chan Event, func()) {
+	c := make(chan Event, buffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = map[chan Event]struct{}{}
+	}
+	h.subs[c] = struct{}{}
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subs, c)
+			close(c)
+		})
+	}
+}
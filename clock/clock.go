@@ -0,0 +1,81 @@
+package clock
+
+import (
+	"time"
+)
+
+// Clock is the source of time used by the locks and the engines, it can be
+// replaced by a fake clock on the tests
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// Since returns the time elapsed since t
+	Since(t time.Time) time.Duration
+
+	// Sleep pauses the current goroutine for the duration
+	Sleep(d time.Duration)
+
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel
+	After(d time.Duration) <-chan time.Time
+
+	// NewTicker returns a new ticker that ticks every d
+	NewTicker(d time.Duration) Ticker
+
+	// NewTimer returns a new timer that sends the current time on its
+	// channel after the duration
+	NewTimer(d time.Duration) Timer
+
+	// AfterFunc calls f after the duration elapses
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker is a time.Ticker
+type Ticker interface {
+	// C returns the channel where the ticks are delivered
+	C() <-chan time.Time
+
+	// Stop stops the ticker
+	Stop()
+}
+
+// Timer is a time.Timer
+type Timer interface {
+	// C returns the channel where the time is delivered, nil for the timers
+	// created with AfterFunc
+	C() <-chan time.Time
+
+	// Stop stops the timer, returns false if the timer already expired or
+	// was stopped
+	Stop() bool
+}
+
+// System is the clock of the system
+var System Clock = &system{}
+
+type system struct{}
+
+func (s *system) Now() time.Time                         { return time.Now() }
+func (s *system) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (s *system) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (s *system) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (s *system) NewTicker(d time.Duration) Ticker       { return &systemTicker{t: time.NewTicker(d)} }
+func (s *system) NewTimer(d time.Duration) Timer         { return &systemTimer{t: time.NewTimer(d)} }
+func (s *system) AfterFunc(d time.Duration, f func()) Timer {
+	return &systemTimer{t: time.AfterFunc(d, f)}
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (s *systemTicker) C() <-chan time.Time { return s.t.C }
+func (s *systemTicker) Stop()               { s.t.Stop() }
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (s *systemTimer) C() <-chan time.Time { return s.t.C }
+func (s *systemTimer) Stop() bool          { return s.t.Stop() }
@@ -0,0 +1,201 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when it's told to, the timers, tickers and
+// sleeps fire deterministically when the time is advanced with Add
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is anything waiting for the time to reach a deadline
+type fakeWaiter struct {
+	deadline time.Time
+	period   time.Duration // only for tickers
+	c        chan time.Time
+	f        func()
+}
+
+// NewFake returns a new fake clock set at t
+func NewFake(t time.Time) *Fake {
+	f := &Fake{now: t}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now satisfies Clock interface
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since satisfies Clock interface
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Sleep satisfies Clock interface, it blocks until the time is advanced
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// After satisfies Clock interface
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	f.add(&fakeWaiter{deadline: f.Now().Add(d), c: c})
+	return c
+}
+
+// NewTicker satisfies Clock interface
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{deadline: f.Now().Add(d), period: d, c: make(chan time.Time, 1)}
+	f.add(w)
+	return &fakeTicker{f: f, w: w}
+}
+
+// NewTimer satisfies Clock interface
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	w := &fakeWaiter{deadline: f.Now().Add(d), c: make(chan time.Time, 1)}
+	f.add(w)
+	return &fakeTimer{f: f, w: w}
+}
+
+// AfterFunc satisfies Clock interface, f will be called on the goroutine
+// that advances the time
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	w := &fakeWaiter{deadline: f.Now().Add(d), f: fn}
+	f.add(w)
+	return &fakeTimer{f: f, w: w}
+}
+
+// Add advances the time firing in order all the timers, tickers and sleeps
+// whose deadline is reached
+func (f *Fake) Add(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	for {
+		w := f.next(end)
+		if w == nil {
+			break
+		}
+		// The deadlines already reached fire at the current time
+		if w.deadline.After(f.now) {
+			f.now = w.deadline
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			f.remove(w)
+		}
+
+		if w.f != nil {
+			// Call without the lock so the function can use the clock
+			f.mu.Unlock()
+			w.f()
+			f.mu.Lock()
+			continue
+		}
+		// Like the real ones, drop the tick if the receiver is slow
+		select {
+		case w.c <- f.now:
+		default:
+		}
+	}
+	if end.After(f.now) {
+		f.now = end
+	}
+	f.mu.Unlock()
+}
+
+// Waiters returns the number of active timers, tickers and sleeps
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until there are n active timers, tickers and sleeps,
+// useful to know that a goroutine is waiting on the clock before advancing
+// the time
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) add(w *fakeWaiter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+// remove removes the waiter, returns false if it wasn't active, it must be
+// called with the clock locked
+func (f *Fake) remove(w *fakeWaiter) bool {
+	for i, fw := range f.waiters {
+		if fw == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the waiter with the closest deadline before end, it must be
+// called with the clock locked
+func (f *Fake) next(end time.Time) *fakeWaiter {
+	if len(f.waiters) == 0 {
+		return nil
+	}
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+	})
+	if f.waiters[0].deadline.After(end) {
+		return nil
+	}
+	return f.waiters[0]
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	t.f.remove(t.w)
+}
+
+type fakeTimer struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.f.remove(t.w)
+}
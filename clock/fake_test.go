@@ -0,0 +1,116 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTimersFireInOrder(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	fired := []string{}
+	f.AfterFunc(2*time.Second, func() { fired = append(fired, "2s") })
+	f.AfterFunc(1*time.Second, func() { fired = append(fired, "1s") })
+	tm := f.AfterFunc(3*time.Second, func() { fired = append(fired, "3s") })
+
+	f.Add(2 * time.Second)
+	if len(fired) != 2 || fired[0] != "1s" || fired[1] != "2s" {
+		t.Errorf("Wrong fired timers: %v", fired)
+	}
+	if !tm.Stop() {
+		t.Errorf("Stopping an active timer should return true")
+	}
+	f.Add(time.Hour)
+	if len(fired) != 2 {
+		t.Errorf("Stopped timer shouldn't fire: %v", fired)
+	}
+	if f.Now() != time.Unix(0, 0).Add(time.Hour+2*time.Second) {
+		t.Errorf("Wrong time: %s", f.Now())
+	}
+}
+
+func TestFakeTimersReachedNotBackwards(t *testing.T) {
+	start := time.Unix(100, 0)
+	f := NewFake(start)
+	var fired time.Time
+	f.AfterFunc(-time.Second, func() { fired = f.Now() })
+	tm := f.NewTimer(0)
+
+	f.Add(time.Second)
+	if fired.Before(start) {
+		t.Errorf("The timer reached should fire at the current time or later; got %s", fired)
+	}
+	if at := <-tm.C(); at.Before(start) {
+		t.Errorf("The timer reached should fire at the current time or later; got %s", at)
+	}
+	if f.Now() != start.Add(time.Second) {
+		t.Errorf("Wrong time: %s", f.Now())
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	tk := f.NewTicker(time.Second)
+
+	f.Add(500 * time.Millisecond)
+	select {
+	case <-tk.C():
+		t.Fatalf("Ticker shouldn't tick before the period")
+	default:
+	}
+
+	f.Add(500 * time.Millisecond)
+	select {
+	case tm := <-tk.C():
+		if tm != time.Unix(1, 0) {
+			t.Errorf("Wrong tick time: %s", tm)
+		}
+	default:
+		t.Fatalf("Ticker should tick after the period")
+	}
+
+	tk.Stop()
+	f.Add(time.Second)
+	select {
+	case <-tk.C():
+		t.Fatalf("Stopped ticker shouldn't tick")
+	default:
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Minute)
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	f.Add(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Sleep should return after advancing the time")
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	tm := f.NewTimer(time.Second)
+	if f.Waiters() != 1 {
+		t.Errorf("There should be 1 waiter; got %d", f.Waiters())
+	}
+
+	f.Add(time.Second)
+	select {
+	case <-tm.C():
+	default:
+		t.Fatalf("Timer should fire after the duration")
+	}
+	if tm.Stop() {
+		t.Errorf("Stopping a fired timer should return false")
+	}
+	if f.Waiters() != 0 {
+		t.Errorf("There shouldn't be waiters; got %d", f.Waiters())
+	}
+}
@@ -0,0 +1,126 @@
+// Command warlock inspects the locks of a warlock engine
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/slok/warlock/engine"
+)
+
+const usage = `usage: warlock <command> [flags]
+
+commands:
+  graph    print the wait-for graph and its deadlocks
+  gc       remove the expired locks
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "graph":
+		err = graph(os.Args[2:], os.Stdout)
+	case "gc":
+		err = gc(os.Args[2:], os.Stdout)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warlock: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// graph runs the graph command
+func graph(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	dsn := fs.String("engine", "", "engine DSN (e.g. file:///var/lock/warlock)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" {
+		return fmt.Errorf("missing engine DSN")
+	}
+
+	e, err := engine.Open(*dsn)
+	if err != nil {
+		return err
+	}
+	defer e.Close(context.Background())
+
+	g := &engine.WaitGraph{Engine: e}
+	edges, err := g.Edges(context.Background())
+	if err != nil {
+		return err
+	}
+	printGraph(out, edges, time.Now())
+	return nil
+}
+
+// gc runs the gc command
+func gc(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dsn := fs.String("engine", "", "engine DSN (e.g. file:///var/lock/warlock)")
+	olderThan := fs.Duration("older-than", 0, "remove the locks expired for longer than this")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" {
+		return fmt.Errorf("missing engine DSN")
+	}
+
+	e, err := engine.Open(*dsn)
+	if err != nil {
+		return err
+	}
+	defer e.Close(context.Background())
+
+	c, ok := e.(engine.Collector)
+	if !ok {
+		return fmt.Errorf("%s engine can't remove the expired locks", e.Name())
+	}
+	removed, err := c.GC(context.Background(), *olderThan)
+	fmt.Fprintf(out, "%d expired locks removed\n", removed)
+	return err
+}
+
+// printGraph prints the edges of the wait-for graph and its deadlocks
+func printGraph(out io.Writer, edges []engine.WaitEdge, now time.Time) {
+	if len(edges) == 0 {
+		fmt.Fprintln(out, "no waiters")
+		return
+	}
+
+	for _, e := range edges {
+		holder := e.Holder
+		if holder == "" {
+			holder = "-"
+		}
+		fmt.Fprintf(out, "%s -> %s (held by %s) waiting %s\n", e.Waiter, e.Key, holder, now.Sub(e.Since).Round(time.Millisecond))
+	}
+
+	cycles := engine.Cycles(edges)
+	if len(cycles) == 0 {
+		return
+	}
+	fmt.Fprintln(out)
+	for _, c := range cycles {
+		owners := []string{}
+		for _, e := range c {
+			owners = append(owners, fmt.Sprintf("%s [%s]", e.Waiter, e.Key))
+		}
+		owners = append(owners, c[0].Waiter)
+		fmt.Fprintf(out, "deadlock: %s, victim %s\n", strings.Join(owners, " -> "), engine.Victim(c).Waiter)
+	}
+}
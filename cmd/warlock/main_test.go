@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/engine"
+)
+
+func TestPrintGraph(t *testing.T) {
+	now := time.Unix(100, 0)
+	tests := []struct {
+		name  string
+		edges []engine.WaitEdge
+		want  string
+	}{
+		{
+			name: "no waiters",
+			want: "no waiters\n",
+		},
+		{
+			name: "waiters",
+			edges: []engine.WaitEdge{
+				{Waiter: "a", Key: "k1", Holder: "b", Since: time.Unix(90, 0)},
+				{Waiter: "c", Key: "k2", Since: time.Unix(95, 0)},
+			},
+			want: "a -> k1 (held by b) waiting 10s\n" +
+				"c -> k2 (held by -) waiting 5s\n",
+		},
+		{
+			name: "deadlock",
+			edges: []engine.WaitEdge{
+				{Waiter: "b", Key: "k1", Holder: "a", Since: time.Unix(95, 0)},
+				{Waiter: "a", Key: "k2", Holder: "b", Since: time.Unix(90, 0)},
+			},
+			want: "b -> k1 (held by a) waiting 5s\n" +
+				"a -> k2 (held by b) waiting 10s\n" +
+				"\n" +
+				"deadlock: a [k2] -> b [k1] -> a, victim b\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printGraph(&out, test.edges, now)
+			if got := out.String(); got != test.want {
+				t.Errorf("output should be %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestGC(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		expErr bool
+	}{
+		{"missing engine", []string{}, true},
+		{"not a collector", []string{"-engine", "memory://"}, true},
+		{"wrong older than", []string{"-engine", "file:///tmp", "-older-than", "wrong"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := gc(test.args, &out)
+			if test.expErr && err == nil {
+				t.Errorf("gc should return an error, it didn't")
+			}
+			if !test.expErr && err != nil {
+				t.Errorf("gc shouldn't return an error: %v", err)
+			}
+		})
+	}
+}
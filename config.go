@@ -0,0 +1,81 @@
+package warlock
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/slok/warlock/engine"
+)
+
+// Environment variables used to load the configuration
+const (
+	DSNEnv = "WARLOCK_DSN"
+	KeyEnv = "WARLOCK_KEY"
+)
+
+// Config is the configuration of a lock so the engine can be selected by
+// configuration
+type Config struct {
+	// DSN is the engine URL, the scheme selects the engine, for example
+	// file:///mnt/locks?ttl=30s&expire=false
+	DSN string `yaml:"dsn"`
+
+	// Key is the lock key
+	Key string `yaml:"key"`
+}
+
+// LoadConfig loads the configuration from YAML
+func LoadConfig(r io.Reader) (*Config, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadConfigFile loads the configuration from a YAML file
+func LoadConfigFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadConfig(f)
+}
+
+// LoadConfigEnv loads the configuration from the environment
+func LoadConfigEnv() *Config {
+	return &Config{
+		DSN: os.Getenv(DSNEnv),
+		Key: os.Getenv(KeyEnv),
+	}
+}
+
+// Open creates a lock with the engine described by the configuration
+func (c *Config) Open() (*Warlock, error) {
+	return Open(c.DSN, c.Key)
+}
+
+// Open creates a lock for the key using the engine described by the URL, the
+// URL scheme selects the engine, for example file:///mnt/locks?ttl=30s
+func Open(dsn, key string) (*Warlock, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	e, err := engine.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Warlock{
+		Key:    key,
+		Engine: e,
+	}, nil
+}
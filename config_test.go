@@ -0,0 +1,84 @@
+package warlock
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/engine"
+)
+
+func init() {
+	engine.Register("test", func(u *url.URL) (engine.Engine, error) {
+		return newTestEngine(), nil
+	})
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		dsn    string
+		expErr bool
+	}{
+		{"test://", false},
+		{"file:///tmp", false},
+		{"missing:///tmp", true},
+		{"file:///tmp?ttl=wrong", true},
+		{"file:///tmp?maxskew=1s&expiry=mtime", false},
+		{"file:///tmp?expiry=wrong", true},
+		{"file:///tmp?mode=0600", false},
+		{"file:///tmp?mode=rw", true},
+		{"file://", true},
+	}
+
+	for _, test := range tests {
+		_, err := Open(test.dsn, key)
+		if test.expErr && err == nil {
+			t.Errorf("%s: Open should return an error, it didn't", test.dsn)
+		}
+		if !test.expErr && err != nil {
+			t.Errorf("%s: Open shouldn't return an error: %v", test.dsn, err)
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	l, err := Open("file:///mnt/locks?ttl=5s&expire=true", key)
+	if err != nil {
+		t.Fatalf("Open shouldn't return an error: %v", err)
+	}
+	f, ok := l.Engine.(*engine.File)
+	if !ok {
+		t.Fatalf("Engine should be a file engine, it wasn't: %T", l.Engine)
+	}
+	if l.Key != key || f.Path != "/mnt/locks" || f.TTL != 5*time.Second || !f.Expire {
+		t.Errorf("Wrong file engine configuration: %+v", f)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader("dsn: test://\nkey: my_key\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig shouldn't return an error: %v", err)
+	}
+	l, err := c.Open()
+	if err != nil {
+		t.Fatalf("Open shouldn't return an error: %v", err)
+	}
+	if l.Key != "my_key" || l.Engine.Name() != "test" {
+		t.Errorf("Wrong lock: %+v", l)
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	os.Setenv(DSNEnv, "test://")
+	os.Setenv(KeyEnv, "my_key")
+	defer os.Unsetenv(DSNEnv)
+	defer os.Unsetenv(KeyEnv)
+
+	c := LoadConfigEnv()
+	if c.DSN != "test://" || c.Key != "my_key" {
+		t.Errorf("Wrong configuration: %+v", c)
+	}
+}
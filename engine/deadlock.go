@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slok/warlock/clock"
+)
+
+const (
+	// waitPrefix is the prefix of the wait records keys
+	waitPrefix = "\x00waits\x00"
+
+	// DefaultWaitCheckInterval is the interval the waiters check for deadlocks
+	// when not set
+	DefaultWaitCheckInterval = time.Second
+)
+
+// WaitEdge is an edge of the wait-for graph: the waiter owner waits for the
+// key held by the holder owner
+type WaitEdge struct {
+	// Waiter is the owner waiting
+	Waiter string
+
+	// Key is the key waited
+	Key string
+
+	// Holder is the owner holding the key, empty if it's not held anymore
+	Holder string
+
+	// Since is the time the waiter started waiting
+	Since time.Time
+}
+
+// WaitGraph is the wait-for graph registry of the owners waiting for locks,
+// the waits are recorded as locks on the engine (that must be a Lister and
+// an Inspector) so the graph is shared by everyone using the engine. The
+// cycles of the graph are deadlocks, the youngest waiter of a cycle is the
+// victim that must abort
+type WaitGraph struct {
+	// Engine is the engine where the waits are recorded
+	Engine Engine
+
+	// TTL is the TTL of the wait records, renewed while waiting, the engine
+	// default if not set
+	TTL time.Duration
+
+	// CheckInterval is the interval the waiters check for deadlocks,
+	// DefaultWaitCheckInterval if not set
+	CheckInterval time.Duration
+
+	// Clock is the clock of the wait records, optional
+	Clock clock.Clock
+}
+
+// Wait records the owner waits for the key, the returned lease must be
+// unlocked when the owner stops waiting
+func (g *WaitGraph) Wait(ctx context.Context, owner, key string) (Lease, error) {
+	record := fmt.Sprintf("%s%d\x00%s\x00%s", waitPrefix, g.clock().Now().UnixNano(), owner, key)
+	return g.Engine.Lock(ctx, record, LockOptions{TTL: g.TTL, Owner: owner})
+}
+
+// Edges returns the edges of the wait-for graph
+func (g *WaitGraph) Edges(ctx context.Context) ([]WaitEdge, error) {
+	lister, ok := g.Engine.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("%s engine can't list the keys", g.Engine.Name())
+	}
+	inspector, ok := g.Engine.(Inspector)
+	if !ok {
+		return nil, fmt.Errorf("%s engine can't tell the holders", g.Engine.Name())
+	}
+
+	records, err := lister.Keys(ctx, waitPrefix)
+	if err != nil {
+		return nil, err
+	}
+	edges := []WaitEdge{}
+	for _, r := range records {
+		fs := strings.SplitN(strings.TrimPrefix(r, waitPrefix), "\x00", 3)
+		if len(fs) != 3 {
+			continue
+		}
+		since, err := strconv.ParseInt(fs[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		holder, err := inspector.Holder(ctx, fs[2])
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, WaitEdge{
+			Waiter: fs[1],
+			Key:    fs[2],
+			Holder: holder,
+			Since:  time.Unix(0, since),
+		})
+	}
+	return edges, nil
+}
+
+// Deadlocks returns the cycles of the wait-for graph
+func (g *WaitGraph) Deadlocks(ctx context.Context) ([][]WaitEdge, error) {
+	edges, err := g.Edges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Cycles(edges), nil
+}
+
+// Victim returns true if the owner waiting for the key is the victim of a
+// deadlock and must abort
+func (g *WaitGraph) Victim(ctx context.Context, owner, key string) (bool, error) {
+	cycles, err := g.Deadlocks(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range cycles {
+		if v := Victim(c); v.Waiter == owner && v.Key == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NextCheck returns the time until the next deadlock check of a waiter
+func (g *WaitGraph) NextCheck() time.Duration {
+	if g.CheckInterval == 0 {
+		return DefaultWaitCheckInterval
+	}
+	return g.CheckInterval
+}
+
+// clock returns the graph clock
+func (g *WaitGraph) clock() clock.Clock {
+	if g.Clock == nil {
+		return clock.System
+	}
+	return g.Clock
+}
+
+// Cycles returns the cycles of the wait-for graph of the edges, every cycle
+// is returned once starting by its oldest wait
+func Cycles(edges []WaitEdge) [][]WaitEdge {
+	byWaiter := map[string][]WaitEdge{}
+	for _, e := range edges {
+		if e.Holder != "" && e.Holder != e.Waiter {
+			byWaiter[e.Waiter] = append(byWaiter[e.Waiter], e)
+		}
+	}
+	waiters := []string{}
+	for w := range byWaiter {
+		waiters = append(waiters, w)
+	}
+	sort.Strings(waiters)
+
+	var (
+		cycles [][]WaitEdge
+		seen   = map[string]bool{}
+		path   []WaitEdge
+		onPath = map[string]int{}
+	)
+	var visit func(owner string)
+	visit = func(owner string) {
+		onPath[owner] = len(path)
+		for _, e := range byWaiter[owner] {
+			if i, ok := onPath[e.Holder]; ok {
+				c := append([]WaitEdge{}, path[i:]...)
+				c = append(c, e)
+				cycles = append(cycles, c)
+				continue
+			}
+			if seen[e.Holder] {
+				continue
+			}
+			path = append(path, e)
+			visit(e.Holder)
+			path = path[:len(path)-1]
+		}
+		delete(onPath, owner)
+		seen[owner] = true
+	}
+	for _, w := range waiters {
+		if !seen[w] {
+			visit(w)
+		}
+	}
+
+	for i, c := range cycles {
+		oldest := 0
+		for j, e := range c {
+			if e.Since.Before(c[oldest].Since) {
+				oldest = j
+			}
+		}
+		cycles[i] = append(c[oldest:], c[:oldest]...)
+	}
+	return cycles
+}
+
+// Victim returns the wait of the cycle that must abort, the youngest one
+func Victim(cycle []WaitEdge) WaitEdge {
+	v := cycle[0]
+	for _, e := range cycle[1:] {
+		if e.Since.After(v.Since) || (e.Since.Equal(v.Since) && e.Waiter > v.Waiter) {
+			v = e
+		}
+	}
+	return v
+}
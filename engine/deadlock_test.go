@@ -0,0 +1,156 @@
+package engine_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/engine"
+)
+
+func TestCycles(t *testing.T) {
+	at := func(s int64) time.Time { return time.Unix(s, 0) }
+	tests := []struct {
+		name      string
+		edges     []engine.WaitEdge
+		expCycles [][]string
+		expVictim []string
+	}{
+		{
+			name: "no waits",
+		},
+		{
+			name: "chain",
+			edges: []engine.WaitEdge{
+				{Waiter: "a", Key: "k2", Holder: "b", Since: at(1)},
+				{Waiter: "b", Key: "k3", Holder: "c", Since: at(2)},
+			},
+		},
+		{
+			name: "released key",
+			edges: []engine.WaitEdge{
+				{Waiter: "a", Key: "k2", Holder: "b", Since: at(1)},
+				{Waiter: "b", Key: "k1", Since: at(2)},
+			},
+		},
+		{
+			name: "two owners",
+			edges: []engine.WaitEdge{
+				{Waiter: "b", Key: "k1", Holder: "a", Since: at(2)},
+				{Waiter: "a", Key: "k2", Holder: "b", Since: at(1)},
+			},
+			expCycles: [][]string{{"a", "b"}},
+			expVictim: []string{"b"},
+		},
+		{
+			name: "three owners",
+			edges: []engine.WaitEdge{
+				{Waiter: "a", Key: "k2", Holder: "b", Since: at(3)},
+				{Waiter: "b", Key: "k3", Holder: "c", Since: at(1)},
+				{Waiter: "c", Key: "k1", Holder: "a", Since: at(2)},
+				{Waiter: "d", Key: "k1", Holder: "a", Since: at(9)},
+			},
+			expCycles: [][]string{{"b", "c", "a"}},
+			expVictim: []string{"a"},
+		},
+		{
+			name: "two deadlocks",
+			edges: []engine.WaitEdge{
+				{Waiter: "a", Key: "k2", Holder: "b", Since: at(1)},
+				{Waiter: "b", Key: "k1", Holder: "a", Since: at(2)},
+				{Waiter: "c", Key: "k4", Holder: "d", Since: at(4)},
+				{Waiter: "d", Key: "k3", Holder: "c", Since: at(3)},
+			},
+			expCycles: [][]string{{"a", "b"}, {"d", "c"}},
+			expVictim: []string{"b", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cycles := engine.Cycles(test.edges)
+			if len(cycles) != len(test.expCycles) {
+				t.Fatalf("there should be %d cycles; got %d", len(test.expCycles), len(cycles))
+			}
+			for i, c := range cycles {
+				waiters := []string{}
+				for _, e := range c {
+					waiters = append(waiters, e.Waiter)
+				}
+				if len(waiters) != len(test.expCycles[i]) {
+					t.Fatalf("cycle should be %v; got %v", test.expCycles[i], waiters)
+				}
+				for j := range waiters {
+					if waiters[j] != test.expCycles[i][j] {
+						t.Errorf("cycle should be %v; got %v", test.expCycles[i], waiters)
+						break
+					}
+				}
+				if v := engine.Victim(c); v.Waiter != test.expVictim[i] {
+					t.Errorf("victim should be %s; got %s", test.expVictim[i], v.Waiter)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitGraph(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Unix(100, 0))
+	e := &engine.Memory{}
+	g := &engine.WaitGraph{Engine: e, Clock: clk}
+
+	if _, err := e.Lock(ctx, "k1", engine.LockOptions{Owner: "a"}); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if _, err := e.Lock(ctx, "k2", engine.LockOptions{Owner: "b"}); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	wa, err := g.Wait(ctx, "a", "k2")
+	if err != nil {
+		t.Fatalf("Wait shouldn't return an error: %v", err)
+	}
+	edges, err := g.Edges(ctx)
+	if err != nil {
+		t.Fatalf("Edges shouldn't return an error: %v", err)
+	}
+	exp := engine.WaitEdge{Waiter: "a", Key: "k2", Holder: "b", Since: time.Unix(100, 0)}
+	if len(edges) != 1 || edges[0] != exp {
+		t.Fatalf("edges should be [%v]; got %v", exp, edges)
+	}
+
+	clk.Add(time.Second)
+	wb, err := g.Wait(ctx, "b", "k1")
+	if err != nil {
+		t.Fatalf("Wait shouldn't return an error: %v", err)
+	}
+	for _, test := range []struct {
+		owner, key string
+		victim     bool
+	}{
+		{"a", "k2", false},
+		{"b", "k1", true},
+	} {
+		victim, err := g.Victim(ctx, test.owner, test.key)
+		if err != nil {
+			t.Errorf("Victim shouldn't return an error: %v", err)
+		}
+		if victim != test.victim {
+			t.Errorf("%s waiting %s victim should be %t; got %t", test.owner, test.key, test.victim, victim)
+		}
+	}
+
+	// Stopping the wait breaks the deadlock
+	if err := wb.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	if cycles, _ := g.Deadlocks(ctx); len(cycles) != 0 {
+		t.Errorf("there shouldn't be deadlocks; got %v", cycles)
+	}
+	wa.Unlock(ctx)
+	if edges, _ := g.Edges(ctx); len(edges) != 0 {
+		t.Errorf("there shouldn't be edges; got %v", edges)
+	}
+}
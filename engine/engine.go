@@ -1,17 +1,145 @@
 package engine
 
+import (
+	"context"
+	"time"
+)
+
+// LockOptions are the options used to lock a key
+type LockOptions struct {
+	// TTL is the time the lock lives if not renewed, if zero the engine
+	// default TTL is used
+	TTL time.Duration
+
+	// Expire makes the lock expire after the TTL instead of being renewed
+	// automatically while held
+	Expire bool
+
+	// Owner is the identity of the lock holder, if empty a random one is
+	// generated
+	Owner string
+
+	// Mode is the mode of the lock on engines with intention locks, exclusive
+	// by default
+	Mode Mode
+
+	// Heartbeat is the window the holder must heartbeat (Lease.Heartbeat)
+	// within to keep the lock, if the holder stops heartbeating the lock is
+	// not renewed anymore and expires. Optional, ignored by the locks that
+	// expire
+	Heartbeat time.Duration
+
+	// Priority is the priority of the lock on engines with queued waiters
+	// (Queuer), the higher priority waiters are granted the lock first.
+	// Optional
+	Priority int
+
+	// Preempt asks the holder of the lock to yield (Lease.Yield) while
+	// queued if the holder has lower priority. Optional
+	Preempt bool
+}
+
 // Engine describes the interface needed to implement by the engines able to
-// be locks
+// be locks, a single engine serves any number of keys
 type Engine interface {
-	// Lock locks a defined key
-	Lock() error
+	// Name returns the name of the engine, used to identify the engine on
+	// the instrumentation
+	Name() string
 
-	// Unlock unlocks a defined key
-	Unlock() error
+	// Lock locks a key, returns the lease of the held lock
+	Lock(ctx context.Context, key string, opts LockOptions) (Lease, error)
 
 	// Locked checks if the key is locked
-	Locked() (bool, error)
+	Locked(ctx context.Context, key string) (bool, error)
+
+	// Wait returns a new channel that will be closed when the lock of the key
+	// is released. Every call returns an independent channel, if the context
+	// is cancelled the wait is stopped and the channel is never closed
+	Wait(ctx context.Context, key string) <-chan struct{}
+
+	// Close stops the renewers and waiters and releases every lock held with
+	// the engine, the engine can't lock anymore after closing it
+	Close(ctx context.Context) error
+}
+
+// Inspector is implemented by the engines that can tell who holds a lock
+type Inspector interface {
+	// Holder returns the owner of the lock of the key, empty if not locked
+	Holder(ctx context.Context, key string) (string, error)
+}
+
+// LockInfo is the state of a held lock as reported by the engine
+type LockInfo struct {
+	// Holder is the owner of the lock
+	Holder string
+
+	// TTL is the time left until the lock expires if not renewed, zero if
+	// unknown or the lock doesn't expire
+	TTL time.Duration
+
+	// Token is the fencing token of the lock, it increases every time the key
+	// is locked. Zero if the engine doesn't support fencing
+	Token uint64
+}
+
+// Describer is implemented by the engines that can describe the held locks
+type Describer interface {
+	// Describe returns the info of the lock of the key, nil if not locked
+	Describe(ctx context.Context, key string) (*LockInfo, error)
+}
+
+// Collector is implemented by the engines that can remove the expired locks
+// left on the backend
+type Collector interface {
+	// GC removes the locks expired more than olderThan ago, returns the
+	// number of removed locks
+	GC(ctx context.Context, olderThan time.Duration) (int, error)
+}
+
+// Lister is implemented by the engines that can list the locked keys
+type Lister interface {
+	// Keys returns the locked keys with the prefix
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Queuer is implemented by the engines that queue the waiters of a key, the
+// lock is granted to the highest priority waiter first and in arrival order
+// within the same priority
+type Queuer interface {
+	// Acquire queues on the key with the options priority and waits until
+	// the lock is granted, returns the lease of the held lock
+	Acquire(ctx context.Context, key string, opts LockOptions) (Lease, error)
+}
+
+// Lease is the handle of a held lock
+type Lease interface {
+	// Key returns the locked key
+	Key() string
+
+	// Unlock releases the lock
+	Unlock(ctx context.Context) error
+
+	// Extend makes the held lock expire d from now, the locks that are
+	// renewed automatically go back to their TTL on the next renewal
+	Extend(ctx context.Context, d time.Duration) error
+
+	// SetTTL changes the TTL of the held lock, it's applied right away and
+	// used by the next renewals
+	SetTTL(ctx context.Context, ttl time.Duration) error
+
+	// Lost returns a channel that is closed when the engine finds out the
+	// lock was lost (expired, taken by other owner or not renewed in time)
+	Lost() <-chan struct{}
+
+	// Heartbeat tells the engine the holder is alive
+	Heartbeat()
+
+	// Yield returns a channel that is closed when a queued waiter with higher
+	// priority asks the holder to release the lock, it's never closed by the
+	// engines without preemption
+	Yield() <-chan struct{}
 
-	// Wait returns a channel that will receive a signal when the lock is released
-	Wait() <-chan struct{}
+	// Token returns the fencing token of the lock, zero if the engine doesn't
+	// support fencing
+	Token() uint64
 }
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrLocked is returned when the key is already locked
+	ErrLocked = errors.New("already locked")
+
+	// ErrNotLocked is returned when the key is not locked
+	ErrNotLocked = errors.New("not locked")
+
+	// ErrNotOwner is returned when the lock is held by other owner
+	ErrNotOwner = errors.New("not the lock owner")
+
+	// ErrLeaseLost is returned when the lease of a held lock expired or was
+	// lost
+	ErrLeaseLost = errors.New("lease lost")
+
+	// ErrCorruptLock is returned when the stored lock can't be understood
+	ErrCorruptLock = errors.New("corrupt lock")
+
+	// ErrBackendUnavailable is returned when the engine backend can't be
+	// reached
+	ErrBackendUnavailable = errors.New("backend unavailable")
+
+	// ErrClosed is returned when locking with a closed engine or lock
+	ErrClosed = errors.New("closed")
+
+	// ErrDeadlock is returned when the waiter is aborted to break a deadlock
+	ErrDeadlock = errors.New("deadlock")
+
+	// ErrPreempted is the cause of the cancellation of the critical sections
+	// whose lock was asked to yield to a higher priority waiter
+	ErrPreempted = errors.New("preempted")
+)
+
+// Error is an error of an operation on a key, it wraps one of the engine
+// errors so it can be checked with errors.Is
+type Error struct {
+	// Engine is the name of the engine
+	Engine string
+
+	// Key is the key of the lock
+	Key string
+
+	// Err is the wrapped error
+	Err error
+}
+
+// NewError returns a new error of the engine for the key wrapping err, the
+// engines should wrap one of the engine errors
+func NewError(engine, key string, err error) *Error {
+	return &Error{
+		Engine: engine,
+		Key:    key,
+		Err:    err,
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s engine: %s: %s", e.Engine, e.Key, e.Err)
+}
+
+// Unwrap returns the wrapped error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
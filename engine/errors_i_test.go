@@ -0,0 +1,105 @@
+// +build integration
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/clock"
+)
+
+// TestFileErrors checks the file engine maps every failure to the engine
+// errors
+func TestFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		action func(f *File, clk *clock.Fake, dir string) error
+		expErr error
+	}{
+		{
+			name: "Locking a held lock",
+			action: func(f *File, clk *clock.Fake, dir string) error {
+				l, _ := f.Lock(context.Background(), testKey, LockOptions{Expire: true})
+				defer l.Unlock(context.Background())
+				_, err := f.Lock(context.Background(), testKey, LockOptions{})
+				return err
+			},
+			expErr: ErrLocked,
+		},
+		{
+			name: "Unlocking a removed lock",
+			action: func(f *File, clk *clock.Fake, dir string) error {
+				l, _ := f.Lock(context.Background(), testKey, LockOptions{Expire: true})
+				os.Remove(path.Join(dir, testKey))
+				return l.Unlock(context.Background())
+			},
+			expErr: ErrNotLocked,
+		},
+		{
+			name: "Unlocking a lock taken by other owner",
+			action: func(f *File, clk *clock.Fake, dir string) error {
+				l, _ := f.Lock(context.Background(), testKey, LockOptions{TTL: 10 * time.Millisecond, Expire: true})
+				clk.Add(10 * time.Millisecond)
+				f.Lock(context.Background(), testKey, LockOptions{Expire: true})
+				return l.Unlock(context.Background())
+			},
+			expErr: ErrNotOwner,
+		},
+		{
+			name: "Unlocking an expired lock",
+			action: func(f *File, clk *clock.Fake, dir string) error {
+				l, _ := f.Lock(context.Background(), testKey, LockOptions{TTL: 10 * time.Millisecond, Expire: true})
+				clk.Add(10 * time.Millisecond)
+				return l.Unlock(context.Background())
+			},
+			expErr: ErrLeaseLost,
+		},
+		{
+			name: "Checking a corrupt lock",
+			action: func(f *File, clk *clock.Fake, dir string) error {
+				ioutil.WriteFile(path.Join(dir, testKey), []byte("wrong"), 0644)
+				_, err := f.Locked(context.Background(), testKey)
+				return err
+			},
+			expErr: ErrCorruptLock,
+		},
+		{
+			name: "Locking on a missing directory",
+			action: func(f *File, clk *clock.Fake, dir string) error {
+				f.Path = path.Join(dir, "missing")
+				_, err := f.Lock(context.Background(), testKey, LockOptions{})
+				return err
+			},
+			expErr: ErrBackendUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "warlock")
+		if err != nil {
+			t.Fatal(err)
+		}
+		clk := clock.NewFake(time.Now())
+		f := &File{
+			Path:  dir,
+			TTL:   1 * time.Second,
+			Clock: clk,
+		}
+
+		err = test.action(f, clk, dir)
+		if !errors.Is(err, test.expErr) {
+			t.Errorf("%s: expected error %q; got %v", test.name, test.expErr, err)
+		}
+		var engErr *Error
+		if !errors.As(err, &engErr) || engErr.Engine != FileName || engErr.Key != testKey {
+			t.Errorf("%s: error should be an engine error with the engine and key, it wasn't: %v", test.name, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -1,168 +1,1480 @@
 package engine
 
 import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
+	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/slok/warlock/audit"
+	"github.com/slok/warlock/clock"
 	"github.com/slok/warlock/log"
+	"github.com/slok/warlock/metrics"
+	"github.com/slok/warlock/tracing"
 )
 
-// File file lock will implement a distributed lock using a shared filesystem
+const (
+	// FileName is the name of the file engine
+	FileName = "file"
+
+	// DefaultFileTTL is the TTL of the file locks opened without TTL
+	DefaultFileTTL = 30 * time.Second
+
+	// fileMaxKeyLen is the max length of the lock file names, leaving room
+	// for the temporary files suffixes on the usual 255 bytes limit
+	fileMaxKeyLen = 200
+
+	// fileStaleWaits is the number of poll intervals a queued waiter can go
+	// without refreshing its queue entry before it's considered dead
+	fileStaleWaits = 3
+)
+
+// FileExpiry is the way the file engine decides if a lock expired
+type FileExpiry int
+
+const (
+	// TimestampExpiry compares the expiration timestamp written by the
+	// holder against the local clock, the clocks of the hosts must be in sync
+	// (up to the max skew)
+	TimestampExpiry FileExpiry = iota
+
+	// MtimeExpiry compares the modification time of the lock file plus the
+	// TTL against the file server time (obtained touching a probe file), so
+	// the clocks of the hosts don't matter
+	MtimeExpiry
+)
+
+func init() {
+	Register(FileName, newFileFromURL)
+}
+
+// newFileFromURL creates a file engine from an URL in the form of
+// file:///mnt/locks?ttl=30s&expire=false&maxskew=1s&expiry=mtime&mode=0600
+func newFileFromURL(u *url.URL) (Engine, error) {
+	if u.Path == "" {
+		return nil, fmt.Errorf("file engine requires a path")
+	}
+	f := &File{
+		Path: u.Path,
+		TTL:  DefaultFileTTL,
+	}
+
+	q := u.Query()
+	if ttl := q.Get("ttl"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("wrong ttl: %s", err)
+		}
+		f.TTL = d
+	}
+	if expire := q.Get("expire"); expire != "" {
+		e, err := strconv.ParseBool(expire)
+		if err != nil {
+			return nil, fmt.Errorf("wrong expire: %s", err)
+		}
+		f.Expire = e
+	}
+	if skew := q.Get("maxskew"); skew != "" {
+		d, err := time.ParseDuration(skew)
+		if err != nil {
+			return nil, fmt.Errorf("wrong maxskew: %s", err)
+		}
+		f.MaxSkew = d
+	}
+	if poll := q.Get("poll"); poll != "" {
+		d, err := time.ParseDuration(poll)
+		if err != nil {
+			return nil, fmt.Errorf("wrong poll: %s", err)
+		}
+		f.PollInterval = d
+	}
+	if mode := q.Get("mode"); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("wrong mode: %s", err)
+		}
+		f.Mode = os.FileMode(m)
+	}
+	switch q.Get("expiry") {
+	case "", "timestamp":
+	case "mtime":
+		f.Expiry = MtimeExpiry
+	default:
+		return nil, fmt.Errorf("wrong expiry: %s", q.Get("expiry"))
+	}
+
+	return f, nil
+}
+
+// File file lock will implement a distributed lock using a shared filesystem,
+// every key is a file on the path
 type File struct {
-	Key    string
-	Path   string
-	TTL    time.Duration
+	// Path is the directory where the lock files are
+	Path string
+
+	// TTL is the default TTL of the locks
+	TTL time.Duration
+
+	// Expire makes all the locks expire instead of being renewed
 	Expire bool
-	ticker *time.Ticker
 
-	pathKey string
-	waiter  chan struct{}
+	// MaxSkew is the max clock difference between the hosts, other holders
+	// locks are considered held until they are expired by this margin
+	MaxSkew time.Duration
+
+	// Expiry is the way the expiration of the locks is checked
+	Expiry FileExpiry
+
+	// PollInterval is the interval the waiters check the lock besides the
+	// filesystem events (not received on filesystems like NFS), if zero the
+	// TTL is used
+	PollInterval time.Duration
+
+	// Metrics is the recorder of the renewal metrics, optional
+	Metrics metrics.Recorder
+
+	// Tracer is the tracer used to trace the renewals, optional
+	Tracer tracing.Tracer
+
+	// Logger is the logger of the engine, optional
+	Logger log.Logger
+
+	// Clock is the source of time of the engine, the system clock if not set
+	Clock clock.Clock
+
+	// Renewal is the policy of the lock renewals, DefaultRenewPolicy if not
+	// set
+	Renewal *RenewPolicy
+
+	// Mode is the permissions of the lock files, DefaultFileMode if not set
+	Mode os.FileMode
+
+	// SigningKey signs the lock files with HMAC-SHA256 so the forged or
+	// modified ones are detected and taken as corrupt, optional
+	SigningKey []byte
+
+	// EncryptionKey encrypts the lock files with AES-GCM (16, 24 or 32 bytes
+	// key) so the holders aren't exposed, optional. The keys are still seen on
+	// the file names
+	EncryptionKey []byte
+
+	// Audit is the sink of the lock transitions seen by the engine:
+	// acquisitions, releases (including the ones on close), renewals,
+	// expirations, broken and lost locks. Optional
+	Audit audit.Sink
+
+	mu     sync.Mutex
+	leases map[*fileLease]struct{}
+	closed bool
+	done   chan struct{}
 }
 
-// Lock will lock using a simple file
-func (f *File) Lock() error {
-	// Check locked first
-	locked, err := f.Locked()
-	if err != nil {
-		return err
+// Name satisfies Engine interface
+func (f *File) Name() string {
+	return FileName
+}
+
+// Lock will lock the key using a simple file
+func (f *File) Lock(ctx context.Context, key string, opts LockOptions) (Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewError(f.Name(), key, err)
 	}
-	if locked {
-		return fmt.Errorf("already locked")
+	if f.isClosed() {
+		return nil, NewError(f.Name(), key, ErrClosed)
+	}
+	if err := f.renewPolicy().Validate(); err != nil {
+		return nil, NewError(f.Name(), key, err)
 	}
 
-	// Lock by creating the key and setting the TTL on the file
-	if err = f.renew(); err != nil {
-		return err
+	l := &fileLease{
+		f:         f,
+		key:       key,
+		pathKey:   f.pathKey(key),
+		tmp:       tmpPath(f.pathKey(key), randomOwner()+".tmp"),
+		ttl:       opts.TTL,
+		expire:    opts.Expire || f.Expire,
+		owner:     opts.Owner,
+		priority:  opts.Priority,
+		heartbeat: opts.Heartbeat,
+		lost:      make(chan struct{}),
+		yield:     make(chan struct{}),
+	}
+	if l.ttl == 0 {
+		l.ttl = f.TTL
+	}
+	if l.owner == "" {
+		l.owner = randomOwner()
 	}
 
-	// If don't expire then we need to renew the key before the TTL
-	if !f.Expire {
-		// every half of the time of the TTL renew
-		f.ticker = time.NewTicker(f.TTL / 2)
-		go func() {
-			for range f.ticker.C {
-				f.renew()
+	// Lock by atomically creating the key file with the TTL, if there is an
+	// expired lock break it and try again
+	for i := 0; i < 2; i++ {
+		created, err := l.create()
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			if err := l.fence(); err != nil {
+				os.Remove(l.pathKey)
+				return nil, err
+			}
+			l.startRenewer()
+			l.emit(audit.Acquired, nil)
+			if !f.track(l) {
+				// Closed while locking
+				l.Unlock(ctx)
+				return nil, NewError(f.Name(), key, ErrClosed)
 			}
-		}()
+			l.logger().Debug("lock acquired")
+			return l, nil
+		}
+
+		broken, err := f.breakExpired(key)
+		if err != nil {
+			return nil, err
+		}
+		if !broken {
+			break
+		}
 	}
 
-	return nil
+	return nil, NewError(f.Name(), key, ErrLocked)
+}
+
+// Acquire satisfies Queuer interface. The waiters are queued with hidden
+// files next to the lock file that they refresh while waiting, the entries
+// not refreshed in a few poll intervals are from dead waiters and ignored.
+// The first waiter of the queue checks the lock when it's released, the rest
+// every poll interval. The Lock calls aren't queued so they can overtake the
+// queued waiters
+func (f *File) Acquire(ctx context.Context, key string, opts LockOptions) (Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewError(f.Name(), key, err)
+	}
+	if f.isClosed() {
+		return nil, NewError(f.Name(), key, ErrClosed)
+	}
+
+	entry := tmpPath(f.pathKey(key), fmt.Sprintf("q.%d.%d.%s", opts.Priority, f.clock().Now().UnixNano(), randomOwner()))
+	defer os.Remove(entry)
+	done := f.closing()
+	for {
+		// Create or refresh the entry so it's not taken as dead
+		if err := ioutil.WriteFile(entry, nil, f.mode()); err != nil {
+			return nil, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+		}
+		first, err := f.queueFirst(key)
+		if err != nil {
+			return nil, err
+		}
+
+		var released <-chan struct{}
+		wctx, cancel := context.WithCancel(ctx)
+		if first == entry {
+			l, err := f.Lock(ctx, key, opts)
+			if !errors.Is(err, ErrLocked) {
+				cancel()
+				return l, err
+			}
+			if opts.Preempt {
+				if err := f.preempt(key, opts.Priority); err != nil {
+					cancel()
+					return nil, err
+				}
+			}
+			released = f.Wait(wctx, key)
+		}
+
+		t := f.clock().NewTimer(f.pollInterval())
+		select {
+		case <-released:
+		case <-t.C():
+		case <-ctx.Done():
+		case <-done:
+		}
+		t.Stop()
+		cancel()
+
+		if err := ctx.Err(); err != nil {
+			return nil, NewError(f.Name(), key, err)
+		}
+		if f.isClosed() {
+			return nil, NewError(f.Name(), key, ErrClosed)
+		}
+	}
 }
 
-// renew will renew the ttl of the lock
-func (f *File) renew() error {
-	pathKey := f.getPathKey()
-	now := time.Now().UTC()
-	t := now.Add(f.TTL)
-	b := []byte(fmt.Sprintf("%d", t.UnixNano()))
-	if err := ioutil.WriteFile(pathKey, b, 0644); err != nil {
+// queueFirst returns the first live entry of the queue of the key, by
+// priority and then by arrival. The dead entries are removed
+func (f *File) queueFirst(key string) (string, error) {
+	prefix := path.Base(tmpPath(f.pathKey(key), "q."))
+	fis, err := ioutil.ReadDir(f.Path)
+	if err != nil {
+		return "", NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	now, err := f.serverNow(key)
+	if err != nil {
+		return "", err
+	}
+
+	type entry struct {
+		name     string
+		priority int
+		arrival  int64
+	}
+	entries := []entry{}
+	for _, fi := range fis {
+		if !strings.HasPrefix(fi.Name(), prefix) {
+			continue
+		}
+		name := path.Join(f.Path, fi.Name())
+		if now.Sub(fi.ModTime()) > fileStaleWaits*f.pollInterval() {
+			os.Remove(name)
+			continue
+		}
+		fs := strings.SplitN(strings.TrimPrefix(fi.Name(), prefix), ".", 3)
+		if len(fs) != 3 {
+			continue
+		}
+		priority, err := strconv.Atoi(fs[0])
+		if err != nil {
+			continue
+		}
+		arrival, err := strconv.ParseInt(fs[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{name: name, priority: priority, arrival: arrival})
+	}
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.arrival != b.arrival {
+			return a.arrival < b.arrival
+		}
+		return a.name < b.name
+	})
+	return entries[0].name, nil
+}
+
+// preempt asks the holder of the key to yield if it has lower priority, the
+// request is a hidden file next to the lock file with the holder owner that
+// the holder checks when renewing, or every poll interval if the lock expires
+func (f *File) preempt(key string, priority int) error {
+	fl, err := f.read(key)
+	if err != nil || fl == nil || fl.priority >= priority {
 		return err
 	}
+	yield := tmpPath(f.pathKey(key), "yield")
+	d, err := f.seal(path.Base(yield), []byte(fl.owner))
+	if err != nil {
+		return NewError(f.Name(), key, err)
+	}
+	if err := ioutil.WriteFile(yield, d, f.mode()); err != nil {
+		return NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
 	return nil
 }
 
-// Unlock unlocks a defined key
-func (f *File) Unlock() error {
-	pathKey := f.getPathKey()
-	// Check locked first
-	locked, err := f.Locked()
+// breakExpired removes the lock file of the key if it's expired, returns true
+// if there isn't a lock file anymore
+func (f *File) breakExpired(key string) (bool, error) {
+	fl, err := f.read(key)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if fl == nil {
+		return true, nil
 	}
-	if !locked {
-		return fmt.Errorf("not locked previously")
+	held, err := f.held(key, fl)
+	if err != nil || held {
+		return false, err
 	}
+	return f.breakLock(key, f.pathKey(key), fl)
+}
 
-	// Unlock removing the key
-	if err := os.Remove(pathKey); err != nil {
-		return err
+// breakLock removes the lock file if it's still the expired lock read,
+// returns true if there isn't a lock file anymore
+func (f *File) breakLock(key, pathKey string, fl *fileLock) (bool, error) {
+	// Move the expired lock out of the way, only one process can move it so
+	// only one will break it
+	stale := tmpPath(pathKey, randomOwner()+".stale")
+	if err := os.Rename(pathKey, stale); err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+
+	// If what we moved is not the expired lock, someone locked meanwhile,
+	// restore it
+	d, err := ioutil.ReadFile(stale)
+	if err != nil {
+		if rerr := f.restore(key, pathKey, stale); rerr != nil {
+			return false, rerr
+		}
+		return false, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
 	}
-	// Stop the renewer
-	if !f.Expire {
-		f.ticker.Stop()
+	moved, err := f.parse(pathKey, d)
+	if err == nil && (moved.owner != fl.owner || !moved.expire.Equal(fl.expire)) {
+		return false, f.restore(key, pathKey, stale)
 	}
+	os.Remove(stale)
+	f.emit(audit.Broken, key, fl.owner, fl.token, nil)
+	f.logger(key).With(log.F(log.OwnerField, fl.owner)).Info("expired lock broken")
+
+	return true, nil
+}
+
+// restore moves back the lock moved out of the way to break it. If it can't
+// be restored the moved file is kept, it could be the lock of a holder
+func (f *File) restore(key, pathKey, stale string) error {
+	if err := os.Link(stale, pathKey); err != nil {
+		return NewError(f.Name(), key, fmt.Errorf("%w: restoring the lock: %s", ErrBackendUnavailable, err))
+	}
+	os.Remove(stale)
 	return nil
 }
 
 // Locked checks if the key is locked
-func (f *File) Locked() (bool, error) {
-	pathKey := f.getPathKey()
-	if _, err := os.Stat(pathKey); os.IsNotExist(err) {
+func (f *File) Locked(ctx context.Context, key string) (bool, error) {
+	fl, err := f.read(key)
+	if err != nil {
+		return true, err
+	}
+	if fl == nil {
 		return false, nil
 	}
 
-	// Check TTL on file
-	d, err := ioutil.ReadFile(pathKey)
+	return f.held(key, fl)
+}
+
+// Keys satisfies Lister interface, the keys cut to fit on the file names
+// are not listed
+func (f *File) Keys(ctx context.Context, prefix string) ([]string, error) {
+	fis, err := ioutil.ReadDir(f.Path)
 	if err != nil {
-		return true, err
+		return nil, NewError(f.Name(), prefix, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
 	}
 
-	// If empty means that the file was created by some other process and the
-	// timestamp set wasn't finished writing, to ensure atomicity in this
-	// operation we wait random time and check again
-	if len(string(d)) == 0 {
-		r := rand.New(rand.NewSource(time.Now().UnixNano()))
-		time.Sleep(time.Duration(r.Intn(100)) * time.Millisecond)
-		d, err = ioutil.ReadFile(pathKey)
+	keys := []string{}
+	for _, fi := range fis {
+		// Temporary files are hidden
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		key, err := DecodeKey(fi.Name())
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		locked, err := f.Locked(ctx, key)
 		if err != nil {
-			return true, err
+			return nil, err
+		}
+		if locked {
+			keys = append(keys, key)
 		}
 	}
+	return keys, nil
+}
+
+// Holder satisfies Inspector interface
+func (f *File) Holder(ctx context.Context, key string) (string, error) {
+	fl, err := f.read(key)
+	if err != nil || fl == nil {
+		return "", err
+	}
+	return fl.owner, nil
+}
+
+// Describe satisfies Describer interface, the TTL is the time left until the
+// lock expires (without the max skew)
+func (f *File) Describe(ctx context.Context, key string) (*LockInfo, error) {
+	fl, err := f.read(key)
+	if err != nil || fl == nil {
+		return nil, err
+	}
+	held, err := f.held(key, fl)
+	if err != nil || !held {
+		return nil, err
+	}
+	expire, err := f.expiration(key, fl)
+	if err != nil {
+		return nil, err
+	}
+	now, err := f.now(key)
+	if err != nil {
+		return nil, err
+	}
+	info := &LockInfo{Holder: fl.owner, Token: fl.token}
+	if ttl := expire.Sub(now); ttl > 0 {
+		info.TTL = ttl
+	}
+	return info, nil
+}
 
-	i, err := strconv.Atoi(string(d))
+// GC satisfies Collector interface. The expired lock files are removed the
+// same way the expired locks are broken when locking, so a lock renewed
+// meanwhile is kept. The hidden files left by dead waiters and holders are
+// removed too, but not the fencing counters so the tokens keep increasing nor
+// the locks that couldn't be restored after breaking them (they are logged)
+func (f *File) GC(ctx context.Context, olderThan time.Duration) (int, error) {
+	fis, err := ioutil.ReadDir(f.Path)
+	if err != nil {
+		return 0, NewError(f.Name(), "", fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	now, err := f.serverNow("")
+	if err != nil {
+		return 0, err
+	}
+	// The hidden files in use are refreshed more often than this
+	minAge := fileStaleWaits * f.pollInterval()
+	if olderThan > minAge {
+		minAge = olderThan
+	}
+
+	removed := 0
+	var errs []error
+	for _, fi := range fis {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		name := fi.Name()
+		if fi.IsDir() {
+			continue
+		}
+		pathKey := path.Join(f.Path, name)
+		if strings.HasPrefix(name, ".") {
+			if strings.HasSuffix(name, ".fence") {
+				continue
+			}
+			if strings.HasSuffix(name, ".stale") {
+				// A lock that couldn't be restored after moving it to break it
+				f.logger(name).Warn("lock moved out of the way left, it could be held")
+				continue
+			}
+			if now.Sub(fi.ModTime()) > minAge {
+				os.Remove(pathKey)
+			}
+			continue
+		}
+
+		// The keys cut to fit on the file names can't be decoded
+		key, err := DecodeKey(name)
+		if err != nil {
+			key = name
+		}
+		ok, err := f.collect(key, pathKey, olderThan)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			f.logger(key).Debug("expired lock removed")
+			removed++
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// collect removes the lock file of the key if it expired more than olderThan
+// ago, returns true if it was removed
+func (f *File) collect(key, pathKey string, olderThan time.Duration) (bool, error) {
+	fl, err := f.readFile(key, pathKey)
+	if err != nil || fl == nil {
+		return false, err
+	}
+	expire, err := f.expiration(key, fl)
+	if err != nil {
+		return false, err
+	}
+	now, err := f.now(key)
+	if err != nil {
+		return false, err
+	}
+	if now.Before(expire.Add(f.MaxSkew + olderThan)) {
+		return false, nil
+	}
+	return f.breakLock(key, pathKey, fl)
+}
+
+// lockedFor returns the time left until the lock of the key expires by the
+// max skew, zero if the key is not locked
+func (f *File) lockedFor(key string) (time.Duration, error) {
+	fl, err := f.read(key)
+	if err != nil || fl == nil {
+		return 0, err
+	}
+	expire, err := f.expiration(key, fl)
+	if err != nil {
+		return 0, err
+	}
+	now, err := f.now(key)
+	if err != nil {
+		return 0, err
+	}
+	if left := expire.Add(f.MaxSkew).Sub(now); left > 0 {
+		return left, nil
+	}
+	return 0, nil
+}
+
+// held checks if a lock of other holder is still held, the lock is held
+// until it's expired by the max skew
+func (f *File) held(key string, fl *fileLock) (bool, error) {
+	expire, err := f.expiration(key, fl)
 	if err != nil {
 		return true, err
 	}
-	t := time.Unix(0, int64(i))
-	now := time.Now().UTC()
-	if now.Before(t) {
-		return true, nil
+	now, err := f.now(key)
+	if err != nil {
+		return true, err
 	}
+	return now.Before(expire.Add(f.MaxSkew)), nil
+}
 
-	return false, nil
+// expiration returns the time the lock expires, on mtime expiry the time is
+// on the file server clock
+func (f *File) expiration(key string, fl *fileLock) (time.Time, error) {
+	if f.Expiry != MtimeExpiry {
+		return fl.expire, nil
+	}
+	if fl.ttl == 0 {
+		return time.Time{}, NewError(f.Name(), key, fmt.Errorf("%w: lock without TTL", ErrCorruptLock))
+	}
+	return fl.mtime.Add(fl.ttl), nil
 }
 
-// Wait will return a channel that will be blocked until
-func (f *File) Wait() <-chan struct{} {
-	// If already waiting return the same channel
-	if f.waiter != nil {
-		return f.waiter
+// now returns the current time to check the expirations, on mtime expiry it
+// is the file server time obtained touching a probe file next to the lock
+func (f *File) now(key string) (time.Time, error) {
+	if f.Expiry != MtimeExpiry {
+		return f.clock().Now().UTC(), nil
 	}
+	return f.serverNow(key)
+}
 
-	// Create a goroutine checking the status and return the waiting channel
-	f.waiter = make(chan struct{})
+// serverNow returns the file server time obtained touching a probe file next
+// to the lock of the key
+func (f *File) serverNow(key string) (time.Time, error) {
+	probe := tmpPath(f.pathKey(key), randomOwner()+".now")
+	if err := ioutil.WriteFile(probe, nil, f.mode()); err != nil {
+		return time.Time{}, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	defer os.Remove(probe)
+	fi, err := os.Stat(probe)
+	if err != nil {
+		return time.Time{}, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	return fi.ModTime().UTC(), nil
+}
+
+// read reads the lock file of the key, returns nil if there is no lock file
+func (f *File) read(key string) (*fileLock, error) {
+	return f.readFile(key, f.pathKey(key))
+}
+
+// readFile reads the lock file of the key on the path, returns nil if there
+// is no lock file
+func (f *File) readFile(key, pathKey string) (*fileLock, error) {
+	file, err := os.Open(pathKey)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	d, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+
+	fl, err := f.parse(pathKey, d)
+	if err != nil {
+		return nil, NewError(f.Name(), key, fmt.Errorf("%w: %s", ErrCorruptLock, err))
+	}
+	fl.mtime = fi.ModTime().UTC()
+	return fl, nil
+}
+
+// parse parses the sealed content of the lock file
+func (f *File) parse(pathKey string, d []byte) (*fileLock, error) {
+	d, err := f.unseal(path.Base(pathKey), d)
+	if err != nil {
+		return nil, err
+	}
+	return parseFileLock(d)
+}
+
+// Wait will return a channel that will be blocked until the key is released.
+// The waiter watches the lock directory to know immediately when the lock is
+// released, it also checks the lock on expiration and every poll interval
+// for the filesystems where the events are not received (like NFS)
+func (f *File) Wait(ctx context.Context, key string) <-chan struct{} {
+	w := make(chan struct{})
+	done := f.closing()
 	go func() {
-		// check every TTL
-		t := time.NewTicker(f.TTL)
+		var events <-chan struct{}
+		pathKey := f.pathKey(key)
+		wt, err := newWatcher(path.Dir(pathKey), path.Base(pathKey))
+		if err != nil {
+			f.logger(key).Debug("could not watch the lock, polling", log.F(log.ErrorField, err))
+		} else {
+			defer wt.Close()
+			events = wt.Events()
+		}
 
-		for range t.C {
-			locked, err := f.Locked()
+		for {
+			next := f.pollInterval()
+			remaining, err := f.lockedFor(key)
 			if err != nil {
-				log.Logger.Error(err.Error())
+				f.logger(key).Error("could not check the lock", log.F(log.ErrorField, err))
+			} else if remaining == 0 {
+				// Close channel to free the wait signal
+				close(w)
+				return
+			} else if remaining < next {
+				next = remaining
 			}
-			// Stop ticker and close channel to free the wait signal
-			if !locked {
+
+			t := f.clock().NewTimer(next)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-done:
 				t.Stop()
-				close(f.waiter)
-				f.waiter = nil
+				return
+			case <-events:
+			case <-t.C():
 			}
+			t.Stop()
 		}
-		log.Logger.Info("4")
 	}()
 
-	return f.waiter
+	return w
+}
+
+// Close satisfies Engine interface, it unlocks all the locks held with the
+// engine and returns the errors of the ones that couldn't be unlocked
+func (f *File) Close(ctx context.Context) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	if f.done != nil {
+		close(f.done)
+	}
+	leases := make([]*fileLease, 0, len(f.leases))
+	for l := range f.leases {
+		leases = append(leases, l)
+	}
+	f.mu.Unlock()
+
+	// The holders are told the leases are lost whether they are unlocked or
+	// not, they aren't renewed anymore
+	var errs []error
+	for _, l := range leases {
+		if err := l.Unlock(ctx); err != nil {
+			l.logger().Error("could not unlock on close", log.F(log.ErrorField, err))
+			errs = append(errs, err)
+		}
+		l.markLost()
+	}
+	return errors.Join(errs...)
+}
+
+// track registers a held lease so it's released on close, returns false if
+// the engine is closed
+func (f *File) track(l *fileLease) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	if f.leases == nil {
+		f.leases = map[*fileLease]struct{}{}
+	}
+	f.leases[l] = struct{}{}
+	return true
+}
+
+// untrack forgets a lease once it has been unlocked
+func (f *File) untrack(l *fileLease) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.leases, l)
+}
+
+// isClosed returns true if the engine is closed
+func (f *File) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// closing returns a channel closed when the engine is closed
+func (f *File) closing() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done == nil {
+		f.done = make(chan struct{})
+		if f.closed {
+			close(f.done)
+		}
+	}
+	return f.done
+}
+
+// pollInterval returns the interval of the waiters checks
+func (f *File) pollInterval() time.Duration {
+	if f.PollInterval == 0 {
+		return f.TTL
+	}
+	return f.PollInterval
+}
+
+// pathKey returns the path of the lock file of a key, the key is encoded so
+// any key is a file on the path
+func (f *File) pathKey(key string) string {
+	return path.Join(f.Path, EncodeKey(key, fileMaxKeyLen))
+}
+
+// tmpPath returns a hidden temporary path next to the lock file
+func tmpPath(pathKey, suffix string) string {
+	dir, file := path.Split(pathKey)
+	return path.Join(dir, fmt.Sprintf(".%s.%s", file, suffix))
+}
+
+// emit emits the audit event of a lock transition
+func (f *File) emit(t audit.EventType, key, owner string, token uint64, err error) {
+	if f.Audit == nil {
+		return
+	}
+	e := audit.Event{
+		Type:   t,
+		Time:   f.clock().Now(),
+		Engine: f.Name(),
+		Key:    key,
+		Owner:  owner,
+		Token:  token,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	f.Audit.Emit(e)
+}
+
+// recorder returns the metrics recorder, the dummy one if not set
+func (f *File) recorder() metrics.Recorder {
+	if f.Metrics == nil {
+		return metrics.Dummy
+	}
+	return f.Metrics
+}
+
+// logger returns the logger with the engine and key fields set, the dummy one
+// if not set
+func (f *File) logger(key string) log.Logger {
+	if f.Logger == nil {
+		return log.Dummy
+	}
+	return f.Logger.With(log.F(log.EngineField, f.Name()), log.F(log.KeyField, key))
+}
+
+// clock returns the clock, the system one if not set
+func (f *File) clock() clock.Clock {
+	if f.Clock == nil {
+		return clock.System
+	}
+	return f.Clock
+}
+
+// renewPolicy returns the renewal policy, the default one if not set
+func (f *File) renewPolicy() *RenewPolicy {
+	if f.Renewal == nil {
+		return DefaultRenewPolicy
+	}
+	return f.Renewal
+}
+
+// tracer returns the tracer, the dummy one if not set
+func (f *File) tracer() tracing.Tracer {
+	if f.Tracer == nil {
+		return tracing.Dummy
+	}
+	return f.Tracer
+}
+
+// fileLock is the content of a lock file: the expiration timestamp, the
+// owner (encoded like the keys so it's one field), the TTL, the priority and
+// the fencing token (if any) of the lock. The modification time of the file
+// is set when reading it
+type fileLock struct {
+	expire   time.Time
+	owner    string
+	ttl      time.Duration
+	priority int
+	token    uint64
+	mtime    time.Time
+}
+
+func parseFileLock(d []byte) (*fileLock, error) {
+	fs := strings.Fields(string(d))
+	if len(fs) < 2 || len(fs) > 5 {
+		return nil, fmt.Errorf("wrong format: %q", d)
+	}
+	i, err := strconv.ParseInt(fs[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := DecodeKey(fs[1])
+	if err != nil {
+		return nil, err
+	}
+	fl := &fileLock{
+		expire: time.Unix(0, i),
+		owner:  owner,
+	}
+	if len(fs) >= 3 {
+		ttl, err := strconv.ParseInt(fs[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		fl.ttl = time.Duration(ttl)
+	}
+	if len(fs) >= 4 {
+		priority, err := strconv.Atoi(fs[3])
+		if err != nil {
+			return nil, err
+		}
+		fl.priority = priority
+	}
+	if len(fs) == 5 {
+		token, err := strconv.ParseUint(fs[4], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		fl.token = token
+	}
+	return fl, nil
+}
+
+func (fl *fileLock) bytes() []byte {
+	owner := EncodeKey(fl.owner, 0)
+	if fl.token != 0 {
+		return []byte(fmt.Sprintf("%d %s %d %d %d", fl.expire.UnixNano(), owner, int64(fl.ttl), fl.priority, fl.token))
+	}
+	if fl.priority != 0 {
+		return []byte(fmt.Sprintf("%d %s %d %d", fl.expire.UnixNano(), owner, int64(fl.ttl), fl.priority))
+	}
+	return []byte(fmt.Sprintf("%d %s %d", fl.expire.UnixNano(), owner, int64(fl.ttl)))
+}
+
+// randomOwner returns a random owner identity
+func randomOwner() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// fileLease is a lock held with the file engine
+type fileLease struct {
+	f         *File
+	key       string
+	pathKey   string
+	tmp       string // random so the locks of the same owner don't share it
+	ttl       time.Duration
+	expire    bool
+	owner     string
+	priority  int
+	token     uint64
+	heartbeat time.Duration
+	stop      chan struct{}
+	reset     chan struct{}
+	stopped   sync.Once
+	lost      chan struct{}
+	lostOne   sync.Once
+	yield     chan struct{}
+	yieldOne  sync.Once
+
+	mu       sync.Mutex
+	timer    clock.Timer
+	expireAt time.Time
+	lastBeat time.Time
+	released bool
+}
+
+// Key satisfies Lease interface
+func (l *fileLease) Key() string {
+	return l.key
+}
+
+// Unlock satisfies Lease interface
+func (l *fileLease) Unlock(ctx context.Context) error {
+	// Stop the renewer, the lease is done whatever happens
+	l.stopRenewer()
+	defer l.f.untrack(l)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		return NewError(l.f.Name(), l.key, ErrNotLocked)
+	}
+
+	// Check we hold the lock first
+	if err := l.check(); err != nil {
+		return err
+	}
+
+	// Unlock removing the key and the yield request if any
+	defer l.checkYield()
+	if err := os.Remove(l.pathKey); err != nil {
+		if os.IsNotExist(err) {
+			return NewError(l.f.Name(), l.key, ErrNotLocked)
+		}
+		return NewError(l.f.Name(), l.key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	l.released = true
+	l.emit(audit.Released, nil)
+	return nil
+}
+
+// Lost satisfies Lease interface
+func (l *fileLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Heartbeat satisfies Lease interface, the locks with heartbeat are not
+// renewed anymore if the holder doesn't heartbeat within the window
+func (l *fileLease) Heartbeat() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastBeat = l.f.clock().Now()
+}
+
+// Yield satisfies Lease interface, the yield requests are checked when the
+// lock is renewed
+func (l *fileLease) Yield() <-chan struct{} {
+	return l.yield
+}
+
+// Token satisfies Lease interface
+func (l *fileLease) Token() uint64 {
+	return l.token
+}
+
+// checkYield checks if there is a yield request for the lease holder and
+// signals it, the request is consumed. It must be called with the lease
+// locked
+func (l *fileLease) checkYield() {
+	yield := tmpPath(l.pathKey, "yield")
+	d, err := ioutil.ReadFile(yield)
+	if err != nil {
+		return
+	}
+	os.Remove(yield)
+	if d, err = l.f.unseal(path.Base(yield), d); err != nil {
+		l.logger().Warn("ignoring a wrong yield request", log.F(log.ErrorField, err))
+		return
+	}
+	if string(d) == l.owner && !l.released {
+		l.yieldOne.Do(func() {
+			close(l.yield)
+		})
+	}
+}
+
+// logger returns the logger with the lease owner set
+func (l *fileLease) logger() log.Logger {
+	return l.f.logger(l.key).With(log.F(log.OwnerField, l.owner))
+}
+
+// emit emits the audit event of a transition of the lease lock
+func (l *fileLease) emit(t audit.EventType, err error) {
+	l.f.emit(t, l.key, l.owner, l.token, err)
+}
+
+// markLost signals the lock was lost
+func (l *fileLease) markLost() {
+	l.lostOne.Do(func() {
+		close(l.lost)
+	})
 }
 
-// Internal method so we don't generate the path key on every check
-func (f *File) getPathKey() string {
-	if f.pathKey == "" {
-		f.pathKey = path.Join(f.Path, f.Key)
+// Extend satisfies Lease interface
+func (l *fileLease) Extend(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return NewError(l.f.Name(), l.key, fmt.Errorf("non-positive extension: %s", d))
 	}
 
-	return f.pathKey
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		return NewError(l.f.Name(), l.key, ErrNotLocked)
+	}
+	if err := l.check(); err != nil {
+		return err
+	}
+	if err := l.renew(d); err != nil {
+		return err
+	}
+	l.resetRenewer()
+	return nil
+}
+
+// SetTTL satisfies Lease interface
+func (l *fileLease) SetTTL(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return NewError(l.f.Name(), l.key, fmt.Errorf("non-positive TTL: %s", ttl))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		return NewError(l.f.Name(), l.key, ErrNotLocked)
+	}
+	if err := l.check(); err != nil {
+		return err
+	}
+	if err := l.renew(ttl); err != nil {
+		return err
+	}
+	l.ttl = ttl
+	l.resetRenewer()
+	return nil
+}
+
+// check checks the lock is still held by the lease, it must be called with
+// the lease locked
+func (l *fileLease) check() error {
+	fl, err := l.f.read(l.key)
+	if err != nil {
+		return err
+	}
+	if fl == nil {
+		if !l.f.clock().Now().UTC().Before(l.expireAt) {
+			return NewError(l.f.Name(), l.key, ErrLeaseLost)
+		}
+		return NewError(l.f.Name(), l.key, ErrNotLocked)
+	}
+	if fl.owner != l.owner {
+		return NewError(l.f.Name(), l.key, ErrNotOwner)
+	}
+	expire, err := l.f.expiration(l.key, fl)
+	if err != nil {
+		return err
+	}
+	now, err := l.f.now(l.key)
+	if err != nil {
+		return err
+	}
+	if !now.Before(expire) {
+		return NewError(l.f.Name(), l.key, ErrLeaseLost)
+	}
+	return nil
+}
+
+// create creates the lock file atomically, the content is written on a
+// temporary file and linked as the lock file so the lock is never seen
+// partially written and only one process can create it. Returns false if
+// the lock file already exists
+func (l *fileLease) create() (bool, error) {
+	tmp, expire, err := l.writeTmp(l.ttl)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, l.pathKey); err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, NewError(l.f.Name(), l.key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	l.expireAt = expire
+	return true, nil
+}
+
+// fence takes the next fencing token of the key right after creating the
+// lock file and publishes it on the lock file. The counter is a hidden file
+// next to the lock file only written by the holder
+func (l *fileLease) fence() error {
+	counter := tmpPath(l.pathKey, "fence")
+	var token uint64
+	d, err := ioutil.ReadFile(counter)
+	if err != nil && !os.IsNotExist(err) {
+		return NewError(l.f.Name(), l.key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	if err == nil {
+		if d, err = l.f.unseal(path.Base(counter), d); err != nil {
+			return NewError(l.f.Name(), l.key, fmt.Errorf("%w: fencing token: %s", ErrCorruptLock, err))
+		}
+		if token, err = strconv.ParseUint(strings.TrimSpace(string(d)), 10, 64); err != nil {
+			return NewError(l.f.Name(), l.key, fmt.Errorf("%w: fencing token: %s", ErrCorruptLock, err))
+		}
+	}
+	token++
+
+	d, err = l.f.seal(path.Base(counter), []byte(strconv.FormatUint(token, 10)))
+	if err != nil {
+		return NewError(l.f.Name(), l.key, err)
+	}
+	tmp := tmpPath(l.pathKey, randomOwner()+".fence.tmp")
+	if err := ioutil.WriteFile(tmp, d, l.f.mode()); err != nil {
+		return NewError(l.f.Name(), l.key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	if err := os.Rename(tmp, counter); err != nil {
+		os.Remove(tmp)
+		return NewError(l.f.Name(), l.key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	l.token = token
+	return l.renew(l.ttl)
+}
+
+// renew will renew the lock to expire in d replacing atomically the lock
+// file, it must be called with the lease locked
+func (l *fileLease) renew(d time.Duration) error {
+	tmp, expire, err := l.writeTmp(d)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.pathKey); err != nil {
+		os.Remove(tmp)
+		return NewError(l.f.Name(), l.key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	l.expireAt = expire
+	return nil
+}
+
+// writeTmp writes the lock expiring in d on a temporary file, returns the
+// file and the expiration written
+func (l *fileLease) writeTmp(d time.Duration) (string, time.Time, error) {
+	now := l.f.clock().Now().UTC()
+	fl := &fileLock{
+		expire:   now.Add(d),
+		owner:    l.owner,
+		ttl:      d,
+		priority: l.priority,
+		token:    l.token,
+	}
+	content, err := l.f.seal(path.Base(l.pathKey), fl.bytes())
+	if err != nil {
+		return "", time.Time{}, NewError(l.f.Name(), l.key, err)
+	}
+	if err := ioutil.WriteFile(l.tmp, content, l.f.mode()); err != nil {
+		return "", time.Time{}, NewError(l.f.Name(), l.key, fmt.Errorf("%w: %s", ErrBackendUnavailable, err))
+	}
+	return l.tmp, fl.expire, nil
+}
+
+// startRenewer starts renewing the lock in background, or watching its
+// expiration if it expires
+func (l *fileLease) startRenewer() {
+	l.lastBeat = l.f.clock().Now()
+	l.stop = make(chan struct{})
+	l.reset = make(chan struct{}, 1)
+	l.schedule(l.next())
+	go l.renewer()
+}
+
+// next returns the time until the next renewal, or until the next check if
+// the lock expires: the expiration or the next poll for yield requests, it
+// must be called with the lease locked
+func (l *fileLease) next() time.Duration {
+	if l.expire {
+		d := l.expireAt.Sub(l.f.clock().Now().UTC())
+		if p := l.f.pollInterval(); p > 0 && p < d {
+			return p
+		}
+		return d
+	}
+	return l.f.renewPolicy().Next(l.ttl)
+}
+
+// renewer renews the lock (or checks its expiration) when the scheduled
+// renewals are due until the lease is released or the lock lost
+func (l *fileLease) renewer() {
+	retry := 0
+	for {
+		l.mu.Lock()
+		t := l.timer
+		l.mu.Unlock()
+
+		select {
+		case <-t.C():
+		case <-l.reset:
+			retry = 0
+			continue
+		case <-l.stop:
+			l.mu.Lock()
+			l.timer.Stop()
+			l.mu.Unlock()
+			return
+		}
+
+		if l.expire {
+			if !l.checkExpired() {
+				return
+			}
+			continue
+		}
+		var ok bool
+		if retry, ok = l.autoRenew(retry); !ok {
+			return
+		}
+	}
+}
+
+// checkExpired marks the lock as lost if expired, otherwise checks the yield
+// requests (the lock isn't renewed) and waits for the next check. Returns
+// false if the lease is released or the lock lost
+func (l *fileLease) checkExpired() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		return false
+	}
+	if d := l.next(); d > 0 {
+		l.checkYield()
+		l.schedule(d)
+		return true
+	}
+	l.emit(audit.Expired, nil)
+	l.markLost()
+	return false
+}
+
+// schedule schedules the next renewal in d, it must be called with the lease
+// locked
+func (l *fileLease) schedule(d time.Duration) {
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.timer = l.f.clock().NewTimer(d)
+}
+
+// resetRenewer schedules the next renewal with the current TTL and
+// expiration, it must be called with the lease locked
+func (l *fileLease) resetRenewer() {
+	if l.reset == nil {
+		return
+	}
+	l.schedule(l.next())
+	select {
+	case l.reset <- struct{}{}:
+	default:
+	}
+}
+
+// stopRenewer stops the renewer if running
+func (l *fileLease) stopRenewer() {
+	if l.stop == nil {
+		return
+	}
+	l.stopped.Do(func() {
+		close(l.stop)
+	})
+}
+
+// autoRenew renews the lock from the renewer and schedules the next renewal
+// or retry (the number of the next retry is returned). Returns false if the
+// renewer must stop because the lease was released or the lock lost, a lock
+// is lost when it's not ours anymore or it couldn't be renewed before the
+// policy deadline
+func (l *fileLease) autoRenew(retry int) (int, bool) {
+	_, span := l.f.tracer().Start(context.Background(), "warlock.engine.renew",
+		tracing.String(tracing.KeyAttr, l.key),
+		tracing.String(tracing.EngineAttr, l.f.Name()),
+	)
+	defer span.End()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		return 0, false
+	}
+	span.SetAttributes(tracing.Int64(tracing.TokenAttr, int64(l.token)))
+
+	// A holder that stopped heartbeating is wedged, let the lock expire
+	if l.heartbeat > 0 && l.f.clock().Since(l.lastBeat) >= l.heartbeat {
+		span.SetAttributes(tracing.String(tracing.OutcomeAttr, "lost"))
+		l.logger().Warn("lock holder stopped heartbeating, not renewing the lock")
+		l.f.recorder().IncLockLost(l.f.Name(), l.key)
+		l.emit(audit.Lost, errors.New("holder stopped heartbeating"))
+		l.markLost()
+		return 0, false
+	}
+
+	// If the lock is not ours anymore we lost it
+	p := l.f.renewPolicy()
+	if err := l.check(); err != nil && !errors.Is(err, ErrBackendUnavailable) {
+		span.SetAttributes(tracing.String(tracing.OutcomeAttr, "lost"))
+		span.RecordError(err)
+		l.logger().Warn("lock lost", log.F(log.ErrorField, err))
+		l.f.recorder().IncLockLost(l.f.Name(), l.key)
+		l.emit(audit.Lost, err)
+		l.markLost()
+		return 0, false
+	}
+
+	if err := l.renew(l.ttl); err != nil {
+		span.SetAttributes(tracing.String(tracing.OutcomeAttr, "error"))
+		span.RecordError(err)
+		l.logger().Error("could not renew the lock", log.F(log.ErrorField, err))
+		l.f.recorder().IncRenewalFailure(l.f.Name(), l.key)
+		l.emit(audit.RenewalFailed, err)
+
+		now := l.f.clock().Now().UTC()
+		deadline := p.Deadline(l.expireAt)
+		if !now.Before(deadline) {
+			l.logger().Warn("lock lost, renewal failed past the deadline")
+			l.f.recorder().IncLockLost(l.f.Name(), l.key)
+			l.emit(audit.Lost, err)
+			l.markLost()
+			return 0, false
+		}
+
+		// Retry or wait for the next interval, but never past the deadline
+		wait, ok := p.RetryWait(retry, l.ttl)
+		if ok {
+			retry++
+		} else {
+			wait, retry = p.Next(l.ttl), 0
+		}
+		if d := deadline.Sub(now); wait > d {
+			wait = d
+		}
+		l.schedule(wait)
+		return retry, true
+	}
+	span.SetAttributes(tracing.String(tracing.OutcomeAttr, "renewed"))
+	l.emit(audit.Renewed, nil)
+	l.checkYield()
+	l.schedule(p.Next(l.ttl))
+	return 0, true
 }
@@ -0,0 +1,75 @@
+// +build integration
+
+package engine_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/slok/warlock/engine"
+	"github.com/slok/warlock/enginetest"
+)
+
+func TestFileConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	enginetest.Run(t, func() engine.Engine {
+		return &engine.File{
+			Path: dir,
+			TTL:  enginetest.TTL,
+		}
+	})
+}
+
+func TestFileMtimeExpiryConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	enginetest.Run(t, func() engine.Engine {
+		return &engine.File{
+			Path:   dir,
+			TTL:    enginetest.TTL,
+			Expiry: engine.MtimeExpiry,
+		}
+	})
+}
+
+func TestFileSealedConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	enginetest.Run(t, func() engine.Engine {
+		return &engine.File{
+			Path:          dir,
+			TTL:           enginetest.TTL,
+			Mode:          0600,
+			SigningKey:    []byte("signing key"),
+			EncryptionKey: []byte("0123456789abcdef"),
+		}
+	})
+}
+
+func TestFileIntentionConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	enginetest.Run(t, func() engine.Engine {
+		return &engine.Intention{Engine: &engine.File{
+			Path: dir,
+			TTL:  enginetest.TTL,
+		}}
+	})
+}
@@ -3,11 +3,24 @@
 package engine
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log/slog"
 	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/slok/warlock/audit"
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/log"
+	"github.com/slok/warlock/metrics"
+	"github.com/slok/warlock/tracing"
 )
 
 const (
@@ -37,14 +50,14 @@ func fileExists(file string) bool {
 func TestLockNoPreviousLock(t *testing.T) {
 	defer func() { os.Remove(testPathKey) }()
 	f := File{
-		Key:  testKey,
 		Path: testPath,
 		TTL:  1 * time.Second,
 	}
-	err := f.Lock()
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
 	if err != nil {
-		t.Errorf("Lock shouldn't return an error: %v", err)
+		t.Fatalf("Lock shouldn't return an error: %v", err)
 	}
+	defer l.Unlock(context.Background())
 
 	if !fileExists(testPathKey) {
 		t.Errorf("File should exist")
@@ -63,16 +76,17 @@ func TestLockMultipleLocks(t *testing.T) {
 		}
 	}()
 
+	// One engine serves all the keys
+	f := File{
+		Path: testPath,
+		TTL:  1 * time.Second,
+	}
 	for i, p := range pathKeys {
-		f := File{
-			Key:  fmt.Sprintf("%s-%d", testKey, i),
-			Path: testPath,
-			TTL:  1 * time.Second,
-		}
-		err := f.Lock()
+		l, err := f.Lock(context.Background(), fmt.Sprintf("%s-%d", testKey, i), LockOptions{})
 		if err != nil {
-			t.Errorf("Lock shouldn't return an error: %v", err)
+			t.Fatalf("Lock shouldn't return an error: %v", err)
 		}
+		defer l.Unlock(context.Background())
 
 		if !fileExists(p) {
 			t.Errorf("File %s should exist", p)
@@ -83,15 +97,15 @@ func TestLockMultipleLocks(t *testing.T) {
 func TestLockPreviousLock(t *testing.T) {
 	defer func() { os.Remove(testPathKey) }()
 	f := File{
-		Key:  testKey,
 		Path: testPath,
 		TTL:  1 * time.Second,
 	}
-	err := f.Lock()
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
 	if err != nil {
-		t.Errorf("Lock shouldn't return an error: %v", err)
+		t.Fatalf("Lock shouldn't return an error: %v", err)
 	}
-	err = f.Lock()
+	defer l.Unlock(context.Background())
+	_, err = f.Lock(context.Background(), testKey, LockOptions{})
 	if err == nil {
 		t.Errorf("Lock should return an error")
 	}
@@ -99,59 +113,579 @@ func TestLockPreviousLock(t *testing.T) {
 
 func TestLockExpire(t *testing.T) {
 	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
 	f := File{
-		Key:    testKey,
 		Path:   testPath,
-		TTL:    10 * time.Millisecond,
+		TTL:    10 * time.Second,
 		Expire: true,
+		Clock:  clk,
 	}
-	err := f.Lock()
+	_, err := f.Lock(context.Background(), testKey, LockOptions{})
 	if err != nil {
 		t.Errorf("Lock shouldn't return an error: %v", err)
 	}
 	if !fileExists(testPathKey) {
 		t.Errorf("File should exist")
 	}
-	time.Sleep(f.TTL)
-	err = f.Lock()
+	clk.Add(f.TTL - time.Nanosecond)
+	_, err = f.Lock(context.Background(), testKey, LockOptions{})
+	if err == nil {
+		t.Errorf("Lock should return an error")
+	}
+	clk.Add(time.Nanosecond)
+	_, err = f.Lock(context.Background(), testKey, LockOptions{})
 	if err != nil {
 		t.Errorf("Lock shouldn't return an error: %v", err)
 	}
 }
 
-func TestLockNotExpire(t *testing.T) {
+func TestLockExpireOptions(t *testing.T) {
 	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
 	f := File{
-		Key:  testKey,
-		Path: testPath,
-		TTL:  50 * time.Millisecond,
+		Path:    testPath,
+		TTL:     1 * time.Minute,
+		Clock:   clk,
+		Renewal: &RenewPolicy{},
 	}
-	err := f.Lock()
+	opts := LockOptions{TTL: 10 * time.Second, Expire: true}
+	_, err := f.Lock(context.Background(), testKey, opts)
 	if err != nil {
 		t.Errorf("Lock shouldn't return an error: %v", err)
 	}
+	clk.Add(opts.TTL)
+	_, err = f.Lock(context.Background(), testKey, opts)
+	if err != nil {
+		t.Errorf("Lock shouldn't return an error: %v", err)
+	}
+}
+
+func TestLockOwnerWithSpaces(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
+	f := File{Path: testPath, TTL: time.Minute, Clock: clk}
+	opts := LockOptions{Owner: "on call", Expire: true}
+	l, err := f.Lock(context.Background(), testKey, opts)
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if holder, err := f.Holder(context.Background(), testKey); err != nil || holder != "on call" {
+		t.Errorf("Holder should be the owner with spaces; got %q: %v", holder, err)
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+
+	// The expired lock can be broken
+	if _, err := f.Lock(context.Background(), testKey, opts); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	clk.Add(f.TTL)
+	if _, err := f.Lock(context.Background(), testKey, LockOptions{Owner: "other", Expire: true}); err != nil {
+		t.Errorf("Lock shouldn't return an error after the expiration: %v", err)
+	}
+}
+
+func TestLockNotExpire(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
+	f := File{
+		Path:    testPath,
+		TTL:     10 * time.Second,
+		Clock:   clk,
+		Renewal: &RenewPolicy{},
+	}
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
 	if !fileExists(testPathKey) {
 		t.Errorf("File should exist")
 	}
-	time.Sleep(f.TTL * 2)
-	err = f.Lock()
+
+	// Every half of the TTL the lock is renewed
+	for i := 1; i <= 4; i++ {
+		clk.BlockUntil(1)
+		clk.Add(f.TTL / 2)
+		waitExpiration(t, testPathKey, clk.Now().Add(f.TTL))
+	}
+	_, err = f.Lock(context.Background(), testKey, LockOptions{})
 	if err == nil {
 		t.Errorf("Lock should return an error")
 	}
 }
 
+func TestLockLogging(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	b := &bytes.Buffer{}
+	clk := clock.NewFake(time.Now())
+	f := File{
+		Path:   testPath,
+		Clock:  clk,
+		Logger: log.NewSlog(slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+	}
+	opts := LockOptions{Owner: "owner1", TTL: time.Minute, Expire: true}
+	if _, err := f.Lock(context.Background(), testKey, opts); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	clk.Add(opts.TTL)
+	opts.Owner = "owner2"
+	l, err := f.Lock(context.Background(), testKey, opts)
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	l.Unlock(context.Background())
+
+	expected := []struct {
+		msg   string
+		owner string
+	}{
+		{"lock acquired", "owner1"},
+		{"expired lock broken", "owner1"},
+		{"lock acquired", "owner2"},
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Wrong number of log messages, expected %d; got %d: %s", len(expected), len(lines), b.String())
+	}
+	for i, exp := range expected {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("Log message should be JSON: %v", err)
+		}
+		if entry["msg"] != exp.msg || entry[log.OwnerField] != exp.owner {
+			t.Errorf("Log message should be %q of %s; got %v", exp.msg, exp.owner, entry)
+		}
+		if entry[log.KeyField] != testKey || entry[log.EngineField] != f.Name() {
+			t.Errorf("Log message should be set with the key and engine: %v", entry)
+		}
+	}
+}
+
+func TestLockSameOwnerConcurrently(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	fs := []*File{{Path: testPath, TTL: time.Minute}, {Path: testPath, TTL: time.Minute}}
+	for round := 0; round < 20; round++ {
+		errs := make(chan error, 8)
+		leases := make(chan Lease, 8)
+		for i := 0; i < cap(errs); i++ {
+			go func(f *File) {
+				l, err := f.Lock(context.Background(), testKey, LockOptions{Owner: "owner1"})
+				if err == nil {
+					leases <- l
+				}
+				errs <- err
+			}(fs[i%len(fs)])
+		}
+		acquired := 0
+		for i := 0; i < cap(errs); i++ {
+			err := <-errs
+			if err == nil {
+				acquired++
+			} else if !errors.Is(err, ErrLocked) {
+				t.Errorf("Lock should only return locked errors: %v", err)
+			}
+		}
+		if acquired != 1 {
+			t.Fatalf("Only one lock should be acquired; got %d", acquired)
+		}
+		if err := (<-leases).Unlock(context.Background()); err != nil {
+			t.Fatalf("Unlock shouldn't return an error: %v", err)
+		}
+	}
+}
+
+func TestLockRenewalTracing(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
+	tr := &tracing.Memory{}
+	f := File{
+		Path:    testPath,
+		TTL:     10 * time.Second,
+		Clock:   clk,
+		Renewal: &RenewPolicy{},
+		Tracer:  tr,
+	}
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	clk.BlockUntil(1)
+	clk.Add(f.TTL / 2)
+	waitExpiration(t, testPathKey, clk.Now().Add(f.TTL))
+	// Waits for the renewal to finish
+	l.Unlock(context.Background())
+
+	spans := tr.Spans()
+	if len(spans) != 1 || spans[0].Name != "warlock.engine.renew" {
+		t.Fatalf("A renew span should be traced; got %+v", spans)
+	}
+	if spans[0].Attributes[tracing.OutcomeAttr] != "renewed" || spans[0].Attributes[tracing.TokenAttr] != int64(l.Token()) {
+		t.Errorf("The renew span should be described with the outcome and token %d; got %v", l.Token(), spans[0].Attributes)
+	}
+}
+
+func TestLockSetTTLRenewal(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
+	f := File{
+		Path:    testPath,
+		TTL:     10 * time.Second,
+		Clock:   clk,
+		Renewal: &RenewPolicy{},
+	}
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+
+	ttl := 2 * time.Second
+	if err := l.SetTTL(context.Background(), ttl); err != nil {
+		t.Fatalf("SetTTL shouldn't return an error: %v", err)
+	}
+	waitExpiration(t, testPathKey, clk.Now().Add(ttl))
+
+	// The renewals use the new TTL
+	for i := 1; i <= 4; i++ {
+		clk.BlockUntil(1)
+		clk.Add(ttl / 2)
+		waitExpiration(t, testPathKey, clk.Now().Add(ttl))
+	}
+}
+
+func TestLockWrongRenewPolicy(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	f := File{
+		Path:    testPath,
+		TTL:     10 * time.Second,
+		Renewal: &RenewPolicy{Fraction: 1},
+	}
+	if _, err := f.Lock(context.Background(), testKey, LockOptions{}); err == nil {
+		t.Errorf("Lock should return an error with a renewal policy renewing after the expiration")
+	}
+	if fileExists(testPathKey) {
+		t.Errorf("File shouldn't exist")
+	}
+}
+
+func TestLockRenewalRetries(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
+	m := metrics.NewPrometheus()
+	f := File{
+		Path:    testPath,
+		TTL:     10 * time.Second,
+		Clock:   clk,
+		Metrics: m,
+		Renewal: &RenewPolicy{Retries: 2, Backoff: time.Second, Margin: 2 * time.Second},
+	}
+	l, err := f.Lock(context.Background(), testKey, LockOptions{Owner: "owner"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+	lockedAt := clk.Now()
+
+	// Break the renewals with a directory on the temporary file
+	tmp := l.(*fileLease).tmp
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp)
+
+	// The failed renewal is retried after the backoff
+	clk.BlockUntil(1)
+	clk.Add(f.TTL / 2)
+	clk.BlockUntil(1)
+	os.Remove(tmp)
+	clk.Add(time.Second)
+	waitExpiration(t, testPathKey, lockedAt.Add(f.TTL/2+time.Second+f.TTL))
+
+	// Until the deadline is reached and the lock is lost
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []time.Duration{f.TTL / 2, time.Second, 2 * time.Second} {
+		clk.BlockUntil(1)
+		clk.Add(d)
+	}
+	lost := `warlock_lock_lost_total{engine="file",key="warlock_test"} 1`
+	b := &bytes.Buffer{}
+	timeout := time.After(time.Second)
+	for !strings.Contains(b.String(), lost) {
+		select {
+		case <-timeout:
+			t.Fatalf("The lock should be lost, it wasn't:\n%s", b.String())
+		case <-time.After(time.Millisecond):
+		}
+		b.Reset()
+		m.WriteTo(b)
+	}
+	exp := `warlock_lock_renewal_failures_total{engine="file",key="warlock_test"} 4`
+	if !strings.Contains(b.String(), exp) {
+		t.Errorf("Metrics should contain %q, they didn't:\n%s", exp, b.String())
+	}
+	if clk.Waiters() != 0 {
+		t.Errorf("The renewer shouldn't schedule renewals after losing the lock")
+	}
+	select {
+	case <-l.Lost():
+	default:
+		t.Errorf("The lost signal should be received, it didn't")
+	}
+}
+
+func TestLockEncodedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := File{
+		Path: dir + "/locks",
+		TTL:  10 * time.Second,
+	}
+	if err := os.Mkdir(f.Path, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys can't escape the lock directory or create directories
+	for _, key := range []string{"../escaped", "a/b", ".", strings.Repeat("k", 500)} {
+		l, err := f.Lock(context.Background(), key, LockOptions{})
+		if err != nil {
+			t.Fatalf("Lock of %q shouldn't return an error: %v", key, err)
+		}
+		defer l.Unlock(context.Background())
+		if locked, _ := f.Locked(context.Background(), key); !locked {
+			t.Errorf("%q should be locked, it wasn't", key)
+		}
+	}
+	if fileExists(dir + "/escaped") {
+		t.Errorf("The lock file shouldn't be out of the lock directory")
+	}
+	fs, _ := ioutil.ReadDir(f.Path)
+	locks := 0
+	for _, fi := range fs {
+		if fi.IsDir() {
+			t.Errorf("The lock directory shouldn't have directories: %s", fi.Name())
+		}
+		// Hidden files are the fencing counters
+		if !strings.HasPrefix(fi.Name(), ".") {
+			locks++
+		}
+	}
+	if locks != 4 {
+		t.Errorf("There should be 4 lock files; got %d", locks)
+	}
+}
+
+func TestLockSealed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &File{
+		Path:          dir,
+		TTL:           10 * time.Second,
+		Mode:          0600,
+		SigningKey:    []byte("signing key"),
+		EncryptionKey: []byte("0123456789abcdef"),
+	}
+
+	l, err := f.Lock(context.Background(), "key", LockOptions{Owner: "owner1"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+	pathKey := f.pathKey("key")
+	fi, err := os.Stat(pathKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("The lock file permissions should be 0600; got %o", fi.Mode().Perm())
+	}
+	d, _ := ioutil.ReadFile(pathKey)
+	if strings.Contains(string(d), "owner1") {
+		t.Errorf("The lock file shouldn't expose the holder: %q", d)
+	}
+	if holder, err := f.Holder(context.Background(), "key"); err != nil || holder != "owner1" {
+		t.Errorf("Holder should be owner1; got %q (%v)", holder, err)
+	}
+
+	// A forged lock file is corrupt
+	forged := &File{Path: dir, TTL: 10 * time.Second}
+	if err := ioutil.WriteFile(pathKey, (&fileLock{expire: time.Now().Add(time.Hour), owner: "forger", ttl: time.Hour}).bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _ := forged.Holder(context.Background(), "key"); holder != "forger" {
+		t.Fatalf("The forged lock should be readable without keys")
+	}
+	if _, err := f.Locked(context.Background(), "key"); !errors.Is(err, ErrCorruptLock) {
+		t.Errorf("Locked should return a corrupt lock error on a forged lock; got %v", err)
+	}
+	if _, err := f.Lock(context.Background(), "key", LockOptions{}); !errors.Is(err, ErrCorruptLock) {
+		t.Errorf("Lock should return a corrupt lock error on a forged lock; got %v", err)
+	}
+}
+
+func TestGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &File{Path: dir, TTL: 10 * time.Second, PollInterval: time.Second}
+	now := time.Now()
+
+	// Expired locks, the old ones are removed
+	for key, expire := range map[string]time.Time{
+		"expired-old":    now.Add(-time.Hour),
+		"expired-recent": now.Add(-time.Second),
+		"not-expired":    now.Add(time.Hour),
+		"x.fence":        now.Add(-time.Hour),
+	} {
+		fl := &fileLock{expire: expire, owner: "dead", ttl: time.Minute}
+		if err := ioutil.WriteFile(f.pathKey(key), fl.bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := f.Lock(context.Background(), "held", LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+
+	// Leftovers of dead waiters and holders, the fencing counters and the
+	// locks not restored are kept
+	old := now.Add(-time.Hour)
+	leftover := tmpPath(f.pathKey("gone"), "q.0.1.dead")
+	counter := tmpPath(f.pathKey("gone"), "fence")
+	stale := tmpPath(f.pathKey("gone"), "dead.stale")
+	for _, p := range []string{leftover, counter, stale} {
+		if err := ioutil.WriteFile(p, []byte("1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, old, old)
+	}
+
+	removed, err := f.GC(context.Background(), time.Minute)
+	if err != nil {
+		t.Fatalf("GC shouldn't return an error: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("GC should remove 2 locks; got %d", removed)
+	}
+	for path, exp := range map[string]bool{
+		f.pathKey("expired-old"):    false,
+		f.pathKey("x.fence"):        false,
+		f.pathKey("expired-recent"): true,
+		f.pathKey("not-expired"):    true,
+		f.pathKey("held"):           true,
+		leftover:                    false,
+		counter:                     true,
+		stale:                       true,
+	} {
+		if fileExists(path) != exp {
+			t.Errorf("%s should exist %t after GC, it didn't", path, exp)
+		}
+	}
+}
+
+func TestBreakLockNotRestored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &File{Path: dir}
+
+	// What is moved out of the way can't be read nor restored (a directory
+	// can't be linked), it's kept
+	if err := os.Mkdir(f.pathKey("key"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fl := &fileLock{expire: time.Now().Add(-time.Hour), owner: "dead", ttl: time.Minute}
+	if _, err := f.breakLock("key", f.pathKey("key"), fl); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("breakLock should return a backend unavailable error, it didn't: %v", err)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || !strings.HasSuffix(fis[0].Name(), ".stale") {
+		t.Errorf("The moved file should be kept; got %v", fis)
+	}
+}
+
+func TestLockWaitGraph(t *testing.T) {
+	ctx := context.Background()
+	f := &File{Path: testPath, TTL: 10 * time.Second}
+	g := &WaitGraph{Engine: f}
+
+	l1, err := f.Lock(ctx, "k1", LockOptions{Owner: "a"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l1.Unlock(ctx)
+	l2, err := f.Lock(ctx, "k2", LockOptions{Owner: "b"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l2.Unlock(ctx)
+
+	for _, w := range []struct{ owner, key string }{{"a", "k2"}, {"b", "k1"}} {
+		l, err := g.Wait(ctx, w.owner, w.key)
+		if err != nil {
+			t.Fatalf("Wait shouldn't return an error: %v", err)
+		}
+		defer l.Unlock(ctx)
+	}
+
+	cycles, err := g.Deadlocks(ctx)
+	if err != nil {
+		t.Fatalf("Deadlocks shouldn't return an error: %v", err)
+	}
+	if len(cycles) != 1 || len(cycles[0]) != 2 {
+		t.Fatalf("There should be a deadlock of 2 owners; got %v", cycles)
+	}
+	if v := Victim(cycles[0]); v.Waiter != "b" || v.Key != "k1" {
+		t.Errorf("The victim should be b waiting k1; got %v", v)
+	}
+}
+
+// waitExpiration waits until the lock file has the expected expiration, the
+// renewals happen on background after advancing the clock
+func waitExpiration(t *testing.T, pathKey string, exp time.Time) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		d, _ := ioutil.ReadFile(pathKey)
+		if fl, err := parseFileLock(d); err == nil && fl.expire.Equal(exp) {
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("Lock should have been renewed to expire at %s, it wasn't", exp)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
 func TestUnLockPreviousLock(t *testing.T) {
 	defer func() { os.Remove(testPathKey) }()
 	f := File{
-		Key:  testKey,
 		Path: testPath,
 		TTL:  1 * time.Second,
 	}
-	err := f.Lock()
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
 	if err != nil {
 		t.Fatalf("Lock shouldn't return an error: %v", err)
 	}
-	err = f.Unlock()
+	err = l.Unlock(context.Background())
 	if err != nil {
 		t.Errorf("Unlock shouldn't return an error: %v", err)
 	}
@@ -161,13 +695,21 @@ func TestUnLockPreviousLock(t *testing.T) {
 	}
 }
 
-func TestUnLockNoPreviousLock(t *testing.T) {
+func TestUnLockExpiredLock(t *testing.T) {
+	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
 	f := File{
-		Key:  testKey,
-		Path: testPath,
-		TTL:  1 * time.Second,
+		Path:   testPath,
+		TTL:    10 * time.Millisecond,
+		Expire: true,
+		Clock:  clk,
 	}
-	err := f.Unlock()
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	clk.Add(f.TTL)
+	err = l.Unlock(context.Background())
 	if err == nil {
 		t.Errorf("Unlock should return an error")
 	}
@@ -175,43 +717,127 @@ func TestUnLockNoPreviousLock(t *testing.T) {
 
 func TestLockWait(t *testing.T) {
 	defer func() { os.Remove(testPathKey) }()
+	clk := clock.NewFake(time.Now())
 	// Create one lock
 	f := File{
-		Key:  testKey,
-		Path: testPath,
-		TTL:  10 * time.Millisecond,
+		Path:    testPath,
+		TTL:     10 * time.Second,
+		Clock:   clk,
+		Renewal: &RenewPolicy{},
 	}
-	err := f.Lock()
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
 	if err != nil {
 		t.Fatalf("Lock shouldn't return an error: %v", err)
 	}
 	// Create a 2nd lock
 	f2 := File{
-		Key:  testKey,
-		Path: testPath,
-		TTL:  10 * time.Millisecond,
+		Path:    testPath,
+		TTL:     10 * time.Second,
+		Clock:   clk,
+		Renewal: &RenewPolicy{},
 	}
-	err = f2.Lock()
+	_, err = f2.Lock(context.Background(), testKey, LockOptions{})
 	if err == nil {
 		t.Fatalf("Lock should return an error")
 	}
 
-	var unlocked bool
-	go func() {
-		// Wait until it unlocks
-		<-f2.Wait()
-		unlocked = true
-	}()
+	// Wait until the waiter is checking the lock with the renewer of the 1st
+	// lock
+	w := f2.Wait(context.Background(), testKey)
+	clk.BlockUntil(2)
 
 	// Check we didn't received while blocked by f (the one with the lock)
-	if unlocked {
+	select {
+	case <-w:
 		t.Errorf("The unlock signal shouldn't be received, it did")
+	default:
 	}
 
-	f.Unlock()
-	time.Sleep(f2.TTL * 5)
+	l.Unlock(context.Background())
+	clk.Add(f2.TTL)
 
-	if !unlocked {
+	select {
+	case <-w:
+	case <-time.After(time.Second):
 		t.Errorf("The unlock signal should be received, it didn't")
 	}
 }
+
+func TestLockAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	h := &audit.Hub{}
+	events, unsubscribe := h.Subscribe(10)
+	defer unsubscribe()
+	f := &File{Path: dir, TTL: time.Minute, Audit: h}
+
+	l, err := f.Lock(context.Background(), testKey, LockOptions{Owner: "owner1"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	// The locks released on close are audited too
+	if _, err := f.Lock(context.Background(), testKey, LockOptions{Owner: "owner2"}); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := f.Close(context.Background()); err != nil {
+		t.Fatalf("Close shouldn't return an error: %v", err)
+	}
+
+	tests := []struct {
+		typ   audit.EventType
+		owner string
+	}{
+		{audit.Acquired, "owner1"},
+		{audit.Released, "owner1"},
+		{audit.Acquired, "owner2"},
+		{audit.Released, "owner2"},
+	}
+	for _, test := range tests {
+		select {
+		case e := <-events:
+			if e.Type != test.typ || e.Engine != f.Name() || e.Key != testKey || e.Owner != test.owner {
+				t.Errorf("The %s event of %s should be emitted; got %+v", test.typ, test.owner, e)
+			}
+		default:
+			t.Errorf("The %s event should be emitted, it wasn't", test.typ)
+		}
+	}
+}
+
+func TestLockYieldExpiring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clk := clock.NewFake(time.Now())
+	f := &File{Path: dir, TTL: time.Minute, PollInterval: time.Second, Clock: clk}
+
+	// The expiring locks aren't renewed, the yield request is polled
+	l, err := f.Lock(context.Background(), testKey, LockOptions{Priority: 1, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+	if err := f.preempt(testKey, 2); err != nil {
+		t.Fatalf("Preempt shouldn't return an error: %v", err)
+	}
+
+	clk.Add(f.PollInterval)
+	select {
+	case <-l.Yield():
+	case <-time.After(time.Second):
+		t.Errorf("The holder of an expiring lock should be asked to yield, it wasn't")
+	}
+	select {
+	case <-l.Lost():
+		t.Errorf("The lock shouldn't be lost before expiring, it was")
+	default:
+	}
+}
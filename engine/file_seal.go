@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// DefaultFileMode is the permissions of the lock files when not set
+	DefaultFileMode os.FileMode = 0644
+)
+
+// seal seals the content of the named file: encrypted with the encryption key
+// and then signed with the signing key, if set. The file name is bound to the
+// content so a sealed content can't be copied to other file
+func (f *File) seal(name string, d []byte) ([]byte, error) {
+	if f.EncryptionKey != nil {
+		aead, err := f.aead()
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := crand.Read(nonce); err != nil {
+			return nil, err
+		}
+		sealed := aead.Seal(nonce, nonce, d, []byte(name))
+		d = []byte(base64.StdEncoding.EncodeToString(sealed))
+	}
+	if f.SigningKey != nil {
+		d = append(d, '\n')
+		d = append(d, hex.EncodeToString(f.sign(name, d[:len(d)-1]))...)
+	}
+	return d, nil
+}
+
+// unseal checks the signature and decrypts the content of the named file
+func (f *File) unseal(name string, d []byte) ([]byte, error) {
+	if f.SigningKey != nil {
+		i := bytes.LastIndexByte(d, '\n')
+		if i < 0 {
+			return nil, errors.New("unsigned content")
+		}
+		mac, err := hex.DecodeString(string(d[i+1:]))
+		if err != nil || !hmac.Equal(mac, f.sign(name, d[:i])) {
+			return nil, errors.New("wrong signature")
+		}
+		d = d[:i]
+	}
+	if f.EncryptionKey != nil {
+		aead, err := f.aead()
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := base64.StdEncoding.DecodeString(string(d))
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return nil, errors.New("not encrypted content")
+		}
+		nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if d, err = aead.Open(nil, nonce, sealed, []byte(name)); err != nil {
+			return nil, fmt.Errorf("could not decrypt: %s", err)
+		}
+	}
+	return d, nil
+}
+
+// sign returns the HMAC-SHA256 of the named file content
+func (f *File) sign(name string, d []byte) []byte {
+	h := hmac.New(sha256.New, f.SigningKey)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(d)
+	return h.Sum(nil)
+}
+
+// aead returns the AES-GCM cipher of the encryption key
+func (f *File) aead() (cipher.AEAD, error) {
+	b, err := aes.NewCipher(f.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrong encryption key: %s", err)
+	}
+	return cipher.NewGCM(b)
+}
+
+// mode returns the permissions of the lock files
+func (f *File) mode() os.FileMode {
+	if f.Mode == 0 {
+		return DefaultFileMode
+	}
+	return f.Mode
+}
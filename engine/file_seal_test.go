@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFileSeal(t *testing.T) {
+	var (
+		signKey = []byte("signing key")
+		encKey  = []byte("0123456789abcdef")
+		content = []byte("1 owner1 30")
+	)
+	tests := []struct {
+		name    string
+		f       *File
+		readF   *File
+		readAs  string
+		tamper  bool
+		expErr  bool
+		visible bool
+	}{
+		{"plain", &File{}, &File{}, "lock", false, false, true},
+		{"signed", &File{SigningKey: signKey}, &File{SigningKey: signKey}, "lock", false, false, true},
+		{"encrypted", &File{EncryptionKey: encKey}, &File{EncryptionKey: encKey}, "lock", false, false, false},
+		{"signed and encrypted", &File{SigningKey: signKey, EncryptionKey: encKey}, &File{SigningKey: signKey, EncryptionKey: encKey}, "lock", false, false, false},
+		{"tampered", &File{SigningKey: signKey}, &File{SigningKey: signKey}, "lock", true, true, true},
+		{"tampered encrypted", &File{EncryptionKey: encKey}, &File{EncryptionKey: encKey}, "lock", true, true, false},
+		{"other file", &File{SigningKey: signKey}, &File{SigningKey: signKey}, "other", false, true, true},
+		{"other file encrypted", &File{EncryptionKey: encKey}, &File{EncryptionKey: encKey}, "other", false, true, false},
+		{"wrong signing key", &File{SigningKey: signKey}, &File{SigningKey: []byte("forger")}, "lock", false, true, true},
+		{"unsigned", &File{}, &File{SigningKey: signKey}, "lock", false, true, true},
+		{"wrong encryption key", &File{EncryptionKey: encKey}, &File{EncryptionKey: []byte("fedcba9876543210")}, "lock", false, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := test.f.seal("lock", content)
+			if err != nil {
+				t.Fatalf("seal shouldn't return an error: %v", err)
+			}
+			if visible := bytes.Contains(d, []byte("owner1")); visible != test.visible {
+				t.Errorf("content visible should be %t; got %t", test.visible, visible)
+			}
+			if test.tamper {
+				d[0]++
+			}
+
+			got, err := test.readF.unseal(test.readAs, d)
+			if test.expErr {
+				if err == nil {
+					t.Errorf("unseal should return an error, it didn't")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unseal shouldn't return an error: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("unsealed content should be %q; got %q", content, got)
+			}
+		})
+	}
+}
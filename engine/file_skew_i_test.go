@@ -0,0 +1,101 @@
+// +build integration
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/clock"
+)
+
+func TestFileTimestampExpiryClockSkew(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSkew time.Duration
+		expErr  error
+	}{
+		{
+			name:   "Without skew margin a fast host steals the lock",
+			expErr: nil,
+		},
+		{
+			name:    "With skew margin a fast host doesn't steal the lock",
+			maxSkew: time.Minute,
+			expErr:  ErrLocked,
+		},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "warlock")
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		holder := &File{Path: dir, TTL: time.Minute, Expire: true, Clock: clock.NewFake(now)}
+		// The fast host clock is 30s ahead of the holder
+		fastClk := clock.NewFake(now.Add(30 * time.Second))
+		fast := &File{Path: dir, TTL: time.Minute, MaxSkew: test.maxSkew, Clock: fastClk}
+
+		if _, err := holder.Lock(context.Background(), testKey, LockOptions{}); err != nil {
+			t.Fatalf("%s: Lock shouldn't return an error: %v", test.name, err)
+		}
+		// At the holder 40s passed, at the fast host it's already expired
+		fastClk.Add(40 * time.Second)
+		l, err := fast.Lock(context.Background(), testKey, LockOptions{Expire: true})
+		if !errors.Is(err, test.expErr) {
+			t.Errorf("%s: expected error %v; got %v", test.name, test.expErr, err)
+		}
+		if l != nil {
+			l.Unlock(context.Background())
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileMtimeExpiryClockSkew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The clocks of the hosts are hours apart, the file server time is the
+	// only one that matters
+	ttl := 100 * time.Millisecond
+	now := time.Now()
+	slow := &File{Path: dir, TTL: ttl, Expire: true, Expiry: MtimeExpiry, Clock: clock.NewFake(now.Add(-time.Hour))}
+	fast := &File{Path: dir, TTL: ttl, Expire: true, Expiry: MtimeExpiry, Clock: clock.NewFake(now.Add(time.Hour))}
+
+	if _, err := slow.Lock(context.Background(), testKey, LockOptions{}); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if locked, err := fast.Locked(context.Background(), testKey); err != nil || !locked {
+		t.Errorf("Key should be locked for the fast host, it wasn't: %v", err)
+	}
+	if _, err := fast.Lock(context.Background(), testKey, LockOptions{}); !errors.Is(err, ErrLocked) {
+		t.Errorf("Lock should return a locked error, it didn't: %v", err)
+	}
+
+	// The expiration follows the file server time and not the host clocks,
+	// age the lock file on the server instead of waiting the TTL
+	aged := time.Now().Add(-2 * ttl)
+	if err := os.Chtimes(path.Join(dir, testKey), aged, aged); err != nil {
+		t.Fatal(err)
+	}
+	l, err := fast.Lock(context.Background(), testKey, LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error after the TTL: %v", err)
+	}
+	if locked, err := slow.Locked(context.Background(), testKey); err != nil || !locked {
+		t.Errorf("Key should be locked for the slow host, it wasn't: %v", err)
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Errorf("Unlock shouldn't return an error: %v", err)
+	}
+}
@@ -0,0 +1,11 @@
+package engine
+
+// watcher notifies the changes of a file
+type watcher interface {
+	// Events returns the channel where the changes are notified, many
+	// changes can be notified with a single event
+	Events() <-chan struct{}
+
+	// Close stops watching
+	Close() error
+}
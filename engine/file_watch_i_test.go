@@ -0,0 +1,60 @@
+// +build integration
+
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileWaitWakesUpOnRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The poll interval is huge so only the filesystem events wake up
+	f := &File{Path: dir, TTL: time.Minute, PollInterval: time.Hour}
+	l, err := f.Lock(context.Background(), testKey, LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	w := f.Wait(context.Background(), testKey)
+	time.Sleep(10 * time.Millisecond)
+	l.Unlock(context.Background())
+
+	select {
+	case <-w:
+	case <-time.After(time.Second):
+		t.Errorf("The unlock signal should be received immediately, it didn't")
+	}
+}
+
+func TestFileWaitWakesUpOnExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &File{Path: dir, TTL: time.Minute, PollInterval: time.Hour}
+	ttl := 50 * time.Millisecond
+	if _, err := f.Lock(context.Background(), testKey, LockOptions{TTL: ttl, Expire: true}); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	start := time.Now()
+	select {
+	case <-f.Wait(context.Background(), testKey):
+		if time.Since(start) > ttl*5 {
+			t.Errorf("The unlock signal should be received on expiry, it took %s", time.Since(start))
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The unlock signal should be received on expiry, it didn't")
+	}
+}
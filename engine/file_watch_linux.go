@@ -0,0 +1,79 @@
+//go:build linux
+// +build linux
+
+package engine
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// inotifyWatcher watches a file of a directory with inotify
+type inotifyWatcher struct {
+	f      *os.File
+	file   string
+	events chan struct{}
+}
+
+// newWatcher watches the changes of the file on the directory
+func newWatcher(dir, file string) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	// The file descriptor is non blocking so the runtime poller is used and
+	// the reads are unblocked when closed
+	w := &inotifyWatcher{
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		file:   file,
+		events: make(chan struct{}, 1),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			name = bytes.TrimRight(name, "\x00")
+			if ev.Mask&syscall.IN_DELETE_SELF == 0 && string(name) != w.file {
+				continue
+			}
+			// Notify without blocking, one pending event is enough
+			select {
+			case w.events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Events satisfies watcher interface
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+// Close satisfies watcher interface
+func (w *inotifyWatcher) Close() error {
+	return w.f.Close()
+}
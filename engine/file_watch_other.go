@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package engine
+
+import (
+	"fmt"
+)
+
+// newWatcher is not supported, the waiters poll the lock
+func newWatcher(dir, file string) (watcher, error) {
+	return nil, fmt.Errorf("file watching not supported")
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slok/warlock/clock"
+)
+
+// hierarchyRetry is the wait before checking again the locks of the waiters
+// after an error
+const hierarchyRetry = 100 * time.Millisecond
+
+// Hierarchy is an engine with hierarchical keys, the keys are paths and
+// locking a key conflicts with the holders of its ancestors and descendants:
+// "a/b" can't be locked while "a" is locked and "a" can't be locked while
+// "a/b" is locked. The wrapped engine must be a Lister
+type Hierarchy struct {
+	// Engine is the wrapped engine
+	Engine Engine
+
+	// Separator is the separator of the key levels, "/" if not set
+	Separator string
+
+	// Clock is the source of time of the engine, the system clock if not set
+	Clock clock.Clock
+}
+
+// Name satisfies Engine interface
+func (h *Hierarchy) Name() string {
+	return h.Engine.Name()
+}
+
+// Lock satisfies Engine interface
+func (h *Hierarchy) Lock(ctx context.Context, key string, opts LockOptions) (Lease, error) {
+	if err := h.checkRelatives(ctx, key); err != nil {
+		return nil, err
+	}
+	l, err := h.Engine.Lock(ctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// An ancestor or descendant could be locked meanwhile
+	if err := h.checkRelatives(ctx, key); err != nil {
+		l.Unlock(ctx)
+		return nil, err
+	}
+	return l, nil
+}
+
+// Locked satisfies Engine interface, the key is locked if it or any of its
+// ancestors or descendants is locked
+func (h *Hierarchy) Locked(ctx context.Context, key string) (bool, error) {
+	k, err := h.lockedBy(ctx, key)
+	return k != "", err
+}
+
+// Wait satisfies Engine interface, it waits until the key, its ancestors and
+// its descendants are released
+func (h *Hierarchy) Wait(ctx context.Context, key string) <-chan struct{} {
+	w := make(chan struct{})
+	go func() {
+		for {
+			k, err := h.lockedBy(ctx, key)
+			if err != nil {
+				// Check again later
+				select {
+				case <-h.clock().After(hierarchyRetry):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			if k == "" {
+				close(w)
+				return
+			}
+			select {
+			case <-h.Engine.Wait(ctx, k):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Close satisfies Engine interface
+func (h *Hierarchy) Close(ctx context.Context) error {
+	return h.Engine.Close(ctx)
+}
+
+// Holder satisfies Inspector interface, it's the holder of the key or of the
+// first locked ancestor or descendant, empty if the wrapped engine is not an inspector
+func (h *Hierarchy) Holder(ctx context.Context, key string) (string, error) {
+	i, ok := h.Engine.(Inspector)
+	if !ok {
+		return "", nil
+	}
+	k, err := h.lockedBy(ctx, key)
+	if err != nil || k == "" {
+		return "", err
+	}
+	return i.Holder(ctx, k)
+}
+
+// Describe satisfies Describer interface, it's the lock of the key or of the
+// first locked ancestor or descendant, nil if the wrapped engine is not a describer
+func (h *Hierarchy) Describe(ctx context.Context, key string) (*LockInfo, error) {
+	d, ok := h.Engine.(Describer)
+	if !ok {
+		return nil, nil
+	}
+	k, err := h.lockedBy(ctx, key)
+	if err != nil || k == "" {
+		return nil, err
+	}
+	return d.Describe(ctx, k)
+}
+
+// checkRelatives returns a locked error if any ancestor or descendant of the
+// key is locked
+func (h *Hierarchy) checkRelatives(ctx context.Context, key string) error {
+	for _, a := range h.ancestors(key) {
+		locked, err := h.Engine.Locked(ctx, a)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return NewError(h.Name(), key, fmt.Errorf("%w: ancestor %s is locked", ErrLocked, a))
+		}
+	}
+	ds, err := h.descendants(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(ds) > 0 {
+		return NewError(h.Name(), key, fmt.Errorf("%w: descendant %s is locked", ErrLocked, ds[0]))
+	}
+	return nil
+}
+
+// lockedBy returns the first locked key of the ancestors of the key, the key
+// and its descendants, empty if none of them is locked
+func (h *Hierarchy) lockedBy(ctx context.Context, key string) (string, error) {
+	for _, k := range append(h.ancestors(key), key) {
+		locked, err := h.Engine.Locked(ctx, k)
+		if err != nil {
+			return "", err
+		}
+		if locked {
+			return k, nil
+		}
+	}
+	ds, err := h.descendants(ctx, key)
+	if err != nil || len(ds) == 0 {
+		return "", err
+	}
+	return ds[0], nil
+}
+
+// descendants returns the locked descendants of the key
+func (h *Hierarchy) descendants(ctx context.Context, key string) ([]string, error) {
+	lister, ok := h.Engine.(Lister)
+	if !ok {
+		return nil, NewError(h.Name(), key, fmt.Errorf("engine can't list the keys"))
+	}
+	return lister.Keys(ctx, key+h.separator())
+}
+
+// separator returns the separator of the key levels
+func (h *Hierarchy) separator() string {
+	if h.Separator == "" {
+		return "/"
+	}
+	return h.Separator
+}
+
+// clock returns the clock, the system one if not set
+func (h *Hierarchy) clock() clock.Clock {
+	if h.Clock == nil {
+		return clock.System
+	}
+	return h.Clock
+}
+
+// ancestors returns the ancestors of the key from the root
+func (h *Hierarchy) ancestors(key string) []string {
+	return ancestors(key, h.Separator)
+}
+
+// ancestors returns the ancestors of the key from the root, the levels are
+// separated by sep ("/" if empty)
+func ancestors(key, sep string) []string {
+	if sep == "" {
+		sep = "/"
+	}
+	as := []string{}
+	for i := strings.Index(key, sep); i > 0; {
+		as = append(as, key[:i])
+		j := strings.Index(key[i+len(sep):], sep)
+		if j < 0 {
+			break
+		}
+		i += len(sep) + j
+	}
+	return as
+}
@@ -0,0 +1,171 @@
+package engine_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/engine"
+)
+
+func TestHierarchyLock(t *testing.T) {
+	tests := []struct {
+		name   string
+		held   string
+		key    string
+		expErr error
+	}{
+		{"parent held", "a", "a/b", engine.ErrLocked},
+		{"ancestor held", "a", "a/b/c", engine.ErrLocked},
+		{"same key held", "a/b", "a/b", engine.ErrLocked},
+		{"sibling held", "a/b", "a/c", nil},
+		{"child held", "a/b", "a", engine.ErrLocked},
+		{"descendant held", "a/b/c", "a", engine.ErrLocked},
+		{"prefix held", "a", "ab/c", nil},
+		{"prefix child held", "ab/c", "a", nil},
+	}
+
+	for _, test := range tests {
+		h := &engine.Hierarchy{Engine: &engine.Memory{}}
+		if _, err := h.Lock(context.Background(), test.held, engine.LockOptions{Owner: "holder"}); err != nil {
+			t.Fatalf("%s: Lock shouldn't return an error: %v", test.name, err)
+		}
+		_, err := h.Lock(context.Background(), test.key, engine.LockOptions{})
+		if test.expErr == nil && err != nil {
+			t.Errorf("%s: Lock shouldn't return an error: %v", test.name, err)
+		}
+		if test.expErr != nil {
+			if !errors.Is(err, test.expErr) {
+				t.Errorf("%s: Lock should return %q; got %v", test.name, test.expErr, err)
+			}
+			if holder, _ := h.Holder(context.Background(), test.key); holder != "holder" {
+				t.Errorf("%s: Holder should be the holder of the ancestor or descendant; got %q", test.name, holder)
+			}
+		}
+	}
+}
+
+func TestHierarchyWait(t *testing.T) {
+	tests := []struct {
+		name string
+		held []string
+		key  string
+	}{
+		{"ancestors held", []string{"a/b", "a"}, "a/b/c"},
+		{"descendants held", []string{"a/b", "a/c/d"}, "a"},
+	}
+
+	for _, test := range tests {
+		m := &engine.Memory{}
+		h := &engine.Hierarchy{Engine: m}
+		// Locked on the wrapped engine, the relatives can't be held together
+		ls := []engine.Lease{}
+		for _, k := range test.held {
+			l, err := m.Lock(context.Background(), k, engine.LockOptions{})
+			if err != nil {
+				t.Fatalf("%s: Lock shouldn't return an error: %v", test.name, err)
+			}
+			ls = append(ls, l)
+		}
+
+		w := h.Wait(context.Background(), test.key)
+		for _, l := range ls {
+			select {
+			case <-w:
+				t.Fatalf("%s: The unlock signal shouldn't be received while a relative is locked, it did", test.name)
+			case <-time.After(10 * time.Millisecond):
+			}
+			l.Unlock(context.Background())
+		}
+		select {
+		case <-w:
+		case <-time.After(time.Second):
+			t.Errorf("%s: The unlock signal should be received, it didn't", test.name)
+		}
+	}
+}
+
+// failingEngine is a memory engine whose Locked and Keys fail while fail is
+// set
+type failingEngine struct {
+	*engine.Memory
+	fail atomic.Bool
+}
+
+func (f *failingEngine) Locked(ctx context.Context, key string) (bool, error) {
+	if f.fail.Load() {
+		return false, engine.ErrBackendUnavailable
+	}
+	return f.Memory.Locked(ctx, key)
+}
+
+func (f *failingEngine) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if f.fail.Load() {
+		return nil, engine.ErrBackendUnavailable
+	}
+	return f.Memory.Keys(ctx, prefix)
+}
+
+func TestHierarchyWaitRetry(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	e := &failingEngine{Memory: &engine.Memory{}}
+	e.fail.Store(true)
+	h := &engine.Hierarchy{Engine: e, Clock: clk}
+
+	// The failed checks are retried with the clock
+	w := h.Wait(context.Background(), "a/b")
+	clk.BlockUntil(1)
+	e.fail.Store(false)
+	select {
+	case <-w:
+		t.Fatalf("The unlock signal shouldn't be received before retrying, it did")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.Add(time.Second)
+	select {
+	case <-w:
+	case <-time.After(time.Second):
+		t.Errorf("The unlock signal should be received after retrying, it didn't")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	m := &engine.Memory{}
+	n1 := &engine.Namespace{Engine: m, Prefix: "app1"}
+	n2 := &engine.Namespace{Engine: m, Prefix: "app2"}
+
+	l, err := n1.Lock(context.Background(), "key", engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if l.Key() != "key" {
+		t.Errorf("The lease key shouldn't have the namespace; got %s", l.Key())
+	}
+	if locked, _ := m.Locked(context.Background(), "app1/key"); !locked {
+		t.Errorf("The key should be locked with the namespace on the engine, it wasn't")
+	}
+	if _, err := n2.Lock(context.Background(), "key", engine.LockOptions{}); err != nil {
+		t.Errorf("Lock on other namespace shouldn't return an error: %v", err)
+	}
+}
+
+func TestOpenNamespaceHierarchy(t *testing.T) {
+	e, err := engine.Open("memory://?namespace=app&hierarchy=true")
+	if err != nil {
+		t.Fatalf("Open shouldn't return an error: %v", err)
+	}
+	h, ok := e.(*engine.Hierarchy)
+	if !ok {
+		t.Fatalf("Engine should be hierarchical; got %T", e)
+	}
+	if n, ok := h.Engine.(*engine.Namespace); !ok || n.Prefix != "app" {
+		t.Errorf("Engine should be namespaced with app; got %#v", h.Engine)
+	}
+
+	if _, err := engine.Open("memory://?hierarchy=wrong"); err == nil {
+		t.Errorf("Open with a wrong hierarchy should return an error, it didn't")
+	}
+}
@@ -0,0 +1,356 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slok/warlock/clock"
+)
+
+const (
+	// intentionSep separates the node from the holder on the engine keys
+	intentionSep = "\x00"
+
+	// intentionGuard is the suffix of the guard key of a node
+	intentionGuard = "guard"
+
+	// intentionGuardTTL is the TTL of the guards of the nodes, they are only
+	// held while checking and locking a node
+	intentionGuardTTL = 5 * time.Second
+)
+
+// Intention is an engine with multi-granularity intention locks over key
+// trees: the keys are paths and locking a key with a mode (LockOptions.Mode)
+// locks its ancestors with the intention mode (IS for S and IS, IX for X and
+// IX) from the root. The modes held on a key must be compatible:
+//
+//	     IS  IX  S   X
+//	IS   y   y   y   n
+//	IX   y   y   n   n
+//	S    y   n   y   n
+//	X    n   n   n   n
+//
+// so a subtree can be locked exclusively while unrelated subtrees are locked
+// concurrently. Every mode held on a key is a lock on the wrapped engine that
+// must be a Lister. The holders are checked between them even with the same
+// owner
+type Intention struct {
+	// Engine is the wrapped engine
+	Engine Engine
+
+	// Separator is the separator of the key levels, "/" if not set
+	Separator string
+
+	// Clock is the source of time of the engine, the system clock if not set
+	Clock clock.Clock
+}
+
+// Name satisfies Engine interface
+func (i *Intention) Name() string {
+	return i.Engine.Name()
+}
+
+// Lock satisfies Engine interface
+func (i *Intention) Lock(ctx context.Context, key string, opts LockOptions) (Lease, error) {
+	lister, ok := i.Engine.(Lister)
+	if !ok {
+		return nil, NewError(i.Name(), key, fmt.Errorf("engine can't list the keys"))
+	}
+	if opts.Owner == "" {
+		opts.Owner = randomOwner()
+	}
+
+	il := &intentionLease{i: i, key: key, done: make(chan struct{})}
+	for _, node := range append(ancestors(key, i.Separator), key) {
+		nopts := opts
+		if node != key {
+			nopts.Mode = opts.Mode.Intention()
+		}
+		l, err := i.lockNode(ctx, lister, key, node, nopts)
+		if err != nil {
+			il.Unlock(ctx)
+			return nil, err
+		}
+		il.leases = append(il.leases, l)
+	}
+	return il, nil
+}
+
+// lockNode locks a node of the key with the mode if it's compatible with the
+// modes held on the node
+func (i *Intention) lockNode(ctx context.Context, lister Lister, key, node string, opts LockOptions) (Lease, error) {
+	guard, err := i.guard(ctx, node)
+	if err != nil {
+		return nil, rekey(err, key)
+	}
+	defer guard.Unlock(ctx)
+
+	holders, err := i.holders(ctx, lister, node)
+	if err != nil {
+		return nil, rekey(err, key)
+	}
+	for _, h := range holders {
+		if m, _ := holderMode(node, h); !m.Compatible(opts.Mode) {
+			return nil, NewError(i.Name(), key, fmt.Errorf("%w: %s held in %s mode", ErrLocked, node, m))
+		}
+	}
+
+	l, err := i.Engine.Lock(ctx, node+intentionSep+opts.Mode.String()+intentionSep+randomOwner(), opts)
+	if err != nil {
+		return nil, rekey(err, key)
+	}
+	return l, nil
+}
+
+// guard locks the guard of the node so only one holder is checking and
+// locking the node
+func (i *Intention) guard(ctx context.Context, node string) (Lease, error) {
+	key := node + intentionSep + intentionGuard
+	for {
+		l, err := i.Engine.Lock(ctx, key, LockOptions{TTL: intentionGuardTTL, Expire: true})
+		if err == nil {
+			return l, nil
+		}
+		if !errors.Is(err, ErrLocked) {
+			return nil, err
+		}
+
+		wctx, cancel := context.WithCancel(ctx)
+		select {
+		case <-i.Engine.Wait(wctx, key):
+			cancel()
+		case <-ctx.Done():
+			cancel()
+			return nil, NewError(i.Name(), key, ctx.Err())
+		}
+	}
+}
+
+// holders returns the engine keys of the holders of the node
+func (i *Intention) holders(ctx context.Context, lister Lister, node string) ([]string, error) {
+	keys, err := lister.Keys(ctx, node+intentionSep)
+	if err != nil {
+		return nil, err
+	}
+	holders := []string{}
+	for _, k := range keys {
+		if _, ok := holderMode(node, k); ok {
+			holders = append(holders, k)
+		}
+	}
+	return holders, nil
+}
+
+// holderMode returns the mode of the holder key of the node, false if it's
+// not a holder key
+func holderMode(node, key string) (Mode, bool) {
+	fs := strings.Split(strings.TrimPrefix(key, node+intentionSep), intentionSep)
+	if len(fs) != 2 {
+		return 0, false
+	}
+	return parseMode(fs[0])
+}
+
+// Locked satisfies Engine interface, the key is locked if it's held with any
+// mode
+func (i *Intention) Locked(ctx context.Context, key string) (bool, error) {
+	holders, err := i.keyHolders(ctx, key)
+	return len(holders) > 0, err
+}
+
+// Wait satisfies Engine interface, it waits until the key is not held with
+// any mode
+func (i *Intention) Wait(ctx context.Context, key string) <-chan struct{} {
+	w := make(chan struct{})
+	go func() {
+		for {
+			holders, err := i.keyHolders(ctx, key)
+			if err == nil && len(holders) == 0 {
+				close(w)
+				return
+			}
+			if err != nil {
+				// Check again later
+				select {
+				case <-i.clock().After(hierarchyRetry):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			select {
+			case <-i.Engine.Wait(ctx, holders[0]):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Close satisfies Engine interface
+func (i *Intention) Close(ctx context.Context) error {
+	return i.Engine.Close(ctx)
+}
+
+// Holder satisfies Inspector interface, it's the owner of the first holder of
+// the key, empty if the wrapped engine is not an inspector
+func (i *Intention) Holder(ctx context.Context, key string) (string, error) {
+	in, ok := i.Engine.(Inspector)
+	if !ok {
+		return "", nil
+	}
+	holders, err := i.keyHolders(ctx, key)
+	if err != nil || len(holders) == 0 {
+		return "", err
+	}
+	return in.Holder(ctx, holders[0])
+}
+
+// Describe satisfies Describer interface, it's the lock of the first holder of
+// the key without fencing token, nil if the wrapped engine is not a describer
+func (i *Intention) Describe(ctx context.Context, key string) (*LockInfo, error) {
+	d, ok := i.Engine.(Describer)
+	if !ok {
+		return nil, nil
+	}
+	holders, err := i.keyHolders(ctx, key)
+	if err != nil || len(holders) == 0 {
+		return nil, err
+	}
+	info, err := d.Describe(ctx, holders[0])
+	if err != nil || info == nil {
+		return nil, rekey(err, key)
+	}
+	info.Token = 0
+	return info, nil
+}
+
+// keyHolders returns the engine keys of the holders of the key
+func (i *Intention) keyHolders(ctx context.Context, key string) ([]string, error) {
+	lister, ok := i.Engine.(Lister)
+	if !ok {
+		return nil, NewError(i.Name(), key, fmt.Errorf("engine can't list the keys"))
+	}
+	holders, err := i.holders(ctx, lister, key)
+	return holders, rekey(err, key)
+}
+
+// clock returns the clock, the system one if not set
+func (i *Intention) clock() clock.Clock {
+	if i.Clock == nil {
+		return clock.System
+	}
+	return i.Clock
+}
+
+// rekey sets the key on the engine error
+func rekey(err error, key string) error {
+	var e *Error
+	if err == nil || !errors.As(err, &e) || e.Key == key {
+		return err
+	}
+	return NewError(e.Engine, key, e.Err)
+}
+
+// intentionLease is the lease of a key and its ancestors
+type intentionLease struct {
+	i      *Intention
+	key    string
+	leases []Lease // from the root
+
+	lostOnce sync.Once
+	lost     chan struct{}
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+// Key satisfies Lease interface
+func (l *intentionLease) Key() string {
+	return l.key
+}
+
+// Unlock satisfies Lease interface, the key and its ancestors are unlocked
+// from the key
+func (l *intentionLease) Unlock(ctx context.Context) error {
+	l.doneOnce.Do(func() { close(l.done) })
+	return l.each(ctx, true, func(ls Lease) error { return ls.Unlock(ctx) })
+}
+
+// Extend satisfies Lease interface
+func (l *intentionLease) Extend(ctx context.Context, d time.Duration) error {
+	return l.each(ctx, false, func(ls Lease) error { return ls.Extend(ctx, d) })
+}
+
+// SetTTL satisfies Lease interface
+func (l *intentionLease) SetTTL(ctx context.Context, ttl time.Duration) error {
+	return l.each(ctx, false, func(ls Lease) error { return ls.SetTTL(ctx, ttl) })
+}
+
+// Heartbeat satisfies Lease interface
+func (l *intentionLease) Heartbeat() {
+	for _, ls := range l.leases {
+		ls.Heartbeat()
+	}
+}
+
+// Yield satisfies Lease interface, the intention locks are never preempted
+func (l *intentionLease) Yield() <-chan struct{} {
+	return nil
+}
+
+// Token satisfies Lease interface, every hold of an intention lock is a
+// different engine key so there is no fencing
+func (l *intentionLease) Token() uint64 {
+	return 0
+}
+
+// each runs f on the leases, from the key if reverse, and returns the errors
+// with the key. A lost lease of a key held by others is reported as not owned
+func (l *intentionLease) each(ctx context.Context, reverse bool, f func(Lease) error) error {
+	var errs []error
+	for n := range l.leases {
+		if reverse {
+			n = len(l.leases) - 1 - n
+		}
+		if err := f(l.leases[n]); err != nil {
+			errs = append(errs, rekey(err, l.key))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	err := errs[0]
+	if len(errs) > 1 {
+		err = errors.Join(errs...)
+	}
+	if errors.Is(err, ErrLeaseLost) {
+		if locked, _ := l.i.Locked(ctx, l.key); locked {
+			return NewError(l.i.Name(), l.key, ErrNotOwner)
+		}
+	}
+	return err
+}
+
+// Lost satisfies Lease interface, the lock is lost if any of the locks of
+// the key and its ancestors is lost
+func (l *intentionLease) Lost() <-chan struct{} {
+	l.lostOnce.Do(func() {
+		l.lost = make(chan struct{})
+		var once sync.Once
+		for _, ls := range l.leases {
+			go func(ls Lease) {
+				select {
+				case <-ls.Lost():
+					once.Do(func() { close(l.lost) })
+				case <-l.done:
+				}
+			}(ls)
+		}
+	})
+	return l.lost
+}
@@ -0,0 +1,128 @@
+package engine_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/engine"
+)
+
+func TestModeCompatible(t *testing.T) {
+	modes := []engine.Mode{engine.IntentionShared, engine.IntentionExclusive, engine.Shared, engine.Exclusive}
+	exp := [][]bool{
+		{true, true, true, false},
+		{true, true, false, false},
+		{true, false, true, false},
+		{false, false, false, false},
+	}
+
+	for i, m1 := range modes {
+		for j, m2 := range modes {
+			if got := m1.Compatible(m2); got != exp[i][j] {
+				t.Errorf("%s and %s compatibility should be %t; got %t", m1, m2, exp[i][j], got)
+			}
+		}
+	}
+}
+
+func TestIntentionLock(t *testing.T) {
+	type lock struct {
+		key  string
+		mode engine.Mode
+	}
+	tests := []struct {
+		name   string
+		held   []lock
+		lock   lock
+		expErr bool
+	}{
+		{"unrelated subtrees", []lock{{"t/p1", engine.Exclusive}}, lock{"t/p2", engine.Exclusive}, false},
+		{"subtree exclusive", []lock{{"t/p1", engine.Exclusive}}, lock{"t/p1/d", engine.Shared}, true},
+		{"parent of exclusive", []lock{{"t/p1/d", engine.Exclusive}}, lock{"t", engine.Shared}, true},
+		{"exclusive parent of shared", []lock{{"t/p1/d", engine.Shared}}, lock{"t/p1", engine.Exclusive}, true},
+		{"shared parent of shared", []lock{{"t/p1/d", engine.Shared}}, lock{"t", engine.Shared}, false},
+		{"shared readers", []lock{{"t/p1", engine.Shared}, {"t/p1", engine.Shared}}, lock{"t/p1/d", engine.Shared}, false},
+		{"writer under readers", []lock{{"t/p1", engine.Shared}}, lock{"t/p1/d", engine.Exclusive}, true},
+		{"root exclusive", []lock{{"t", engine.Exclusive}}, lock{"u", engine.Exclusive}, false},
+	}
+
+	for _, test := range tests {
+		e := &engine.Intention{Engine: &engine.Memory{}}
+		for _, h := range test.held {
+			if _, err := e.Lock(context.Background(), h.key, engine.LockOptions{Mode: h.mode, Owner: "holder"}); err != nil {
+				t.Fatalf("%s: Lock of %s shouldn't return an error: %v", test.name, h.key, err)
+			}
+		}
+
+		l, err := e.Lock(context.Background(), test.lock.key, engine.LockOptions{Mode: test.lock.mode})
+		if test.expErr {
+			if !errors.Is(err, engine.ErrLocked) {
+				t.Errorf("%s: Lock should return a locked error; got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Lock shouldn't return an error: %v", test.name, err)
+			continue
+		}
+		if err := l.Unlock(context.Background()); err != nil {
+			t.Errorf("%s: Unlock shouldn't return an error: %v", test.name, err)
+		}
+	}
+}
+
+func TestIntentionUnlockReleasesAncestors(t *testing.T) {
+	e := &engine.Intention{Engine: &engine.Memory{}}
+	l, err := e.Lock(context.Background(), "t/p1/d", engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if _, err := e.Lock(context.Background(), "t", engine.LockOptions{}); !errors.Is(err, engine.ErrLocked) {
+		t.Errorf("Lock of the ancestor should return a locked error; got %v", err)
+	}
+
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	for _, key := range []string{"t", "t/p1", "t/p1/d"} {
+		if locked, _ := e.Locked(context.Background(), key); locked {
+			t.Errorf("%s should be unlocked, it wasn't", key)
+		}
+	}
+	if _, err := e.Lock(context.Background(), "t", engine.LockOptions{}); err != nil {
+		t.Errorf("Lock of the ancestor shouldn't return an error: %v", err)
+	}
+}
+
+func TestIntentionNotLister(t *testing.T) {
+	e := &engine.Intention{Engine: &engine.Namespace{Engine: &engine.Hierarchy{Engine: &engine.Memory{}}}}
+	if _, err := e.Lock(context.Background(), "key", engine.LockOptions{}); err == nil {
+		t.Errorf("Lock should return an error when the engine can't list the keys, it didn't")
+	}
+}
+
+func TestIntentionWaitRetry(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	e := &failingEngine{Memory: &engine.Memory{}}
+	e.fail.Store(true)
+	i := &engine.Intention{Engine: e, Clock: clk}
+
+	// The failed checks are retried with the clock
+	w := i.Wait(context.Background(), "a/b")
+	clk.BlockUntil(1)
+	e.fail.Store(false)
+	select {
+	case <-w:
+		t.Fatalf("The unlock signal shouldn't be received before retrying, it did")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.Add(time.Second)
+	select {
+	case <-w:
+	case <-time.After(time.Second):
+		t.Errorf("The unlock signal should be received after retrying, it didn't")
+	}
+}
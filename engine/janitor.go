@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/log"
+)
+
+const (
+	// DefaultJanitorInterval is the interval of the janitor collections when
+	// not set
+	DefaultJanitorInterval = 10 * time.Minute
+)
+
+// Janitor removes the expired locks of an engine periodically in background
+type Janitor struct {
+	// Collector is the engine collected
+	Collector Collector
+
+	// Interval is the interval between collections, DefaultJanitorInterval if
+	// not set
+	Interval time.Duration
+
+	// OlderThan is the time the locks must be expired to be removed,
+	// optional
+	OlderThan time.Duration
+
+	// Logger is the logger of the janitor, optional
+	Logger log.Logger
+
+	// Clock is the source of time of the janitor, the system clock if not set
+	Clock clock.Clock
+}
+
+// Run collects the engine every interval until the context is cancelled, the
+// failed collections are logged and retried on the next interval
+func (j *Janitor) Run(ctx context.Context) {
+	for {
+		t := j.clock().NewTimer(j.interval())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C():
+		}
+
+		removed, err := j.Collector.GC(ctx, j.OlderThan)
+		if err != nil && ctx.Err() == nil {
+			j.logger().Error("could not collect the expired locks", log.F(log.ErrorField, err))
+		}
+		if removed > 0 {
+			j.logger().Info("expired locks removed", log.F("removed", removed))
+		}
+	}
+}
+
+// interval returns the interval between collections
+func (j *Janitor) interval() time.Duration {
+	if j.Interval == 0 {
+		return DefaultJanitorInterval
+	}
+	return j.Interval
+}
+
+// clock returns the clock, the system one if not set
+func (j *Janitor) clock() clock.Clock {
+	if j.Clock == nil {
+		return clock.System
+	}
+	return j.Clock
+}
+
+// logger returns the logger, the dummy one if not set
+func (j *Janitor) logger() log.Logger {
+	if j.Logger == nil {
+		return log.Dummy
+	}
+	return j.Logger
+}
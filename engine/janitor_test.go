@@ -0,0 +1,59 @@
+package engine_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/engine"
+)
+
+// testCollector records the collections
+type testCollector struct {
+	calls chan time.Duration
+}
+
+func (c *testCollector) GC(ctx context.Context, olderThan time.Duration) (int, error) {
+	c.calls <- olderThan
+	return 0, errors.New("wrong")
+}
+
+func TestJanitor(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c := &testCollector{calls: make(chan time.Duration, 1)}
+	j := &engine.Janitor{
+		Collector: c,
+		Interval:  time.Minute,
+		OlderThan: time.Hour,
+		Clock:     clk,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(stopped)
+	}()
+
+	// The failed collections don't stop the janitor
+	for i := 0; i < 2; i++ {
+		clk.BlockUntil(1)
+		clk.Add(time.Minute)
+		select {
+		case olderThan := <-c.calls:
+			if olderThan != time.Hour {
+				t.Errorf("GC should collect the locks older than 1h; got %s", olderThan)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("The janitor should collect every interval, it didn't")
+		}
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("The janitor should stop when the context is cancelled, it didn't")
+	}
+}
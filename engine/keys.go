@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// EncodeKey encodes an arbitrary key into an identifier valid on backends
+// with restricted names (file names, nodes...). Letters, digits, '-' and '_'
+// are kept and the rest of the bytes are percent-encoded, the dots too except
+// in the middle of the key so it's never hidden or relative ('.', '..').
+// If maxLen is set the keys longer than it are cut and suffixed with the hash
+// of the key so they stay unique. Keys that are already valid are kept as
+// they are
+func EncodeKey(key string, maxLen int) string {
+	if key == "" {
+		return "%"
+	}
+
+	b := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+			b = append(b, c)
+		case c == '.' && i != 0 && i != len(key)-1:
+			b = append(b, c)
+		default:
+			b = append(b, fmt.Sprintf("%%%02X", c)...)
+		}
+	}
+
+	if maxLen > 0 && len(b) > maxLen {
+		sum := sha256.Sum256([]byte(key))
+		h := hex.EncodeToString(sum[:])
+		if maxLen <= len(h)+1 {
+			return h[:maxLen]
+		}
+		return string(b[:maxLen-len(h)-1]) + "~" + h
+	}
+	return string(b)
+}
+
+// DecodeKey decodes a key encoded with EncodeKey, it fails for the keys cut
+// to the max length as they can't be decoded
+func DecodeKey(encoded string) (string, error) {
+	if encoded == "%" {
+		return "", nil
+	}
+
+	b := make([]byte, 0, len(encoded))
+	for i := 0; i < len(encoded); i++ {
+		c := encoded[i]
+		if c == '~' {
+			return "", fmt.Errorf("cut key %q can't be decoded", encoded)
+		}
+		if c != '%' {
+			b = append(b, c)
+			continue
+		}
+		if i+2 >= len(encoded) {
+			return "", fmt.Errorf("wrong encoded key %q", encoded)
+		}
+		d, err := hex.DecodeString(encoded[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("wrong encoded key %q", encoded)
+		}
+		b = append(b, d[0])
+		i += 2
+	}
+	return string(b), nil
+}
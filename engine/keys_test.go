@@ -0,0 +1,64 @@
+package engine_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/slok/warlock/engine"
+)
+
+func TestEncodeKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		maxLen int
+		exp    string
+	}{
+		{"safe", "my_key-1", 0, "my_key-1"},
+		{"dots in the middle", "my.key", 0, "my.key"},
+		{"empty", "", 0, "%"},
+		{"slashes", "a/b", 0, "a%2Fb"},
+		{"relative", "../etc", 0, "%2E.%2Fetc"},
+		{"dot", ".", 0, "%2E"},
+		{"hidden", ".key", 0, "%2Ekey"},
+		{"percent", "100%", 0, "100%25"},
+		{"unicode", "ñ", 0, "%C3%B1"},
+		{"short enough", "my_key", 6, "my_key"},
+	}
+
+	for _, test := range tests {
+		if got := engine.EncodeKey(test.key, test.maxLen); got != test.exp {
+			t.Errorf("%s: %q should be encoded as %q; got %q", test.name, test.key, test.exp, got)
+		}
+	}
+}
+
+func TestEncodeKeyLong(t *testing.T) {
+	k1 := strings.Repeat("a", 300)
+	k2 := k1 + "b"
+	e1, e2 := engine.EncodeKey(k1, 100), engine.EncodeKey(k2, 100)
+	if len(e1) != 100 || len(e2) != 100 {
+		t.Errorf("Long keys should be cut to the max length; got %d and %d", len(e1), len(e2))
+	}
+	if e1 == e2 {
+		t.Errorf("Long keys with the same prefix should be encoded differently, they weren't: %s", e1)
+	}
+	if !strings.HasPrefix(e1, "aaaa") {
+		t.Errorf("Long keys should keep their prefix, they didn't: %s", e1)
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	for _, key := range []string{"my_key", "", "../etc", "a/b", ".", "100%", "ñ", "a\x00b"} {
+		got, err := engine.DecodeKey(engine.EncodeKey(key, 0))
+		if err != nil || got != key {
+			t.Errorf("%q should be decoded; got %q (%v)", key, got, err)
+		}
+	}
+
+	for _, encoded := range []string{"a%", "a%zz", engine.EncodeKey(strings.Repeat("a", 300), 100)} {
+		if _, err := engine.DecodeKey(encoded); err == nil {
+			t.Errorf("%q shouldn't be decoded, it was", encoded)
+		}
+	}
+}
@@ -0,0 +1,606 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slok/warlock/audit"
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/log"
+	"github.com/slok/warlock/metrics"
+	"github.com/slok/warlock/tracing"
+)
+
+const (
+	// MemoryName is the name of the memory engine
+	MemoryName = "memory"
+
+	// DefaultMemoryTTL is the TTL of the memory locks opened without TTL
+	DefaultMemoryTTL = 30 * time.Second
+)
+
+func init() {
+	Register(MemoryName, newMemoryFromURL)
+}
+
+// newMemoryFromURL creates a memory engine from an URL in the form of
+// memory://?ttl=30s
+func newMemoryFromURL(u *url.URL) (Engine, error) {
+	m := &Memory{TTL: DefaultMemoryTTL}
+	if ttl := u.Query().Get("ttl"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("wrong ttl: %s", err)
+		}
+		m.TTL = d
+	}
+	return m, nil
+}
+
+// memoryLock is a lock held in memory
+type memoryLock struct {
+	owner     string
+	priority  int
+	token     uint64
+	expire    time.Time
+	heartbeat bool
+	timer     clock.Timer
+	lost      chan struct{}
+	yield     chan struct{}
+	yielded   bool
+}
+
+// memoryWaiter is a waiter queued on a key, the lease (or the error) is sent
+// when the lock is granted
+type memoryWaiter struct {
+	opts    LockOptions
+	granted chan memoryGrant
+}
+
+// memoryGrant is the result of a queued waiter
+type memoryGrant struct {
+	lease Lease
+	err   error
+}
+
+// Memory is an in-process engine, the locks are only shared between the users
+// of the same engine instance. Locks that don't expire are held until
+// released, there is no need to renew them
+type Memory struct {
+	// TTL is the default TTL of the expiring locks, the expiring locks without
+	// TTL are rejected if not set
+	TTL time.Duration
+
+	// Clock is the source of time of the engine, the system clock if not set
+	Clock clock.Clock
+
+	// Metrics is the recorder of the lost locks metrics, optional
+	Metrics metrics.Recorder
+
+	// Tracer is the tracer used to trace the expirations, optional
+	Tracer tracing.Tracer
+
+	// Logger is the logger of the engine, optional
+	Logger log.Logger
+
+	// Audit is the sink of the lock transitions seen by the engine: the
+	// acquisitions, releases (including the ones on close) and expirations
+	// (including the missed heartbeats). Optional
+	Audit audit.Sink
+
+	mu      sync.Mutex
+	locks   map[string]*memoryLock
+	waiters map[string][]chan struct{}
+	queues  map[string][]*memoryWaiter
+	tokens  map[string]uint64
+	closed  bool
+}
+
+// Name satisfies Engine interface
+func (m *Memory) Name() string {
+	return MemoryName
+}
+
+// Lock satisfies Engine interface
+func (m *Memory) Lock(ctx context.Context, key string, opts LockOptions) (Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewError(m.Name(), key, err)
+	}
+
+	if err := m.checkTTL(opts); err != nil {
+		return nil, NewError(m.Name(), key, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, NewError(m.Name(), key, ErrClosed)
+	}
+	if _, ok := m.locks[key]; ok {
+		return nil, NewError(m.Name(), key, ErrLocked)
+	}
+
+	return m.lock(key, opts), nil
+}
+
+// Acquire satisfies Queuer interface, when the lock is released it's handed
+// over to the first waiter of the queue so the queued waiters can't be
+// overtaken
+func (m *Memory) Acquire(ctx context.Context, key string, opts LockOptions) (Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewError(m.Name(), key, err)
+	}
+
+	if err := m.checkTTL(opts); err != nil {
+		return nil, NewError(m.Name(), key, err)
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, NewError(m.Name(), key, ErrClosed)
+	}
+	if _, ok := m.locks[key]; !ok {
+		defer m.mu.Unlock()
+		return m.lock(key, opts), nil
+	}
+
+	// Queue after the waiters with the same or higher priority
+	w := &memoryWaiter{opts: opts, granted: make(chan memoryGrant, 1)}
+	if m.queues == nil {
+		m.queues = map[string][]*memoryWaiter{}
+	}
+	q := m.queues[key]
+	i := len(q)
+	for i > 0 && q[i-1].opts.Priority < opts.Priority {
+		i--
+	}
+	q = append(q, nil)
+	copy(q[i+1:], q[i:])
+	q[i] = w
+	m.queues[key] = q
+	if opts.Preempt {
+		m.preempt(key, opts.Priority)
+	}
+	m.mu.Unlock()
+
+	select {
+	case g := <-w.granted:
+		return g.lease, g.err
+	case <-ctx.Done():
+	}
+
+	// Give up, if the lock was granted meanwhile release it
+	m.mu.Lock()
+	dequeued := m.dequeue(key, w)
+	m.mu.Unlock()
+	if !dequeued {
+		if g := <-w.granted; g.lease != nil {
+			g.lease.Unlock(context.Background())
+		}
+	}
+	return nil, NewError(m.Name(), key, ctx.Err())
+}
+
+// checkTTL checks the expiring locks have a TTL, they would expire right away
+func (m *Memory) checkTTL(opts LockOptions) error {
+	if opts.Expire && opts.TTL <= 0 && m.TTL <= 0 {
+		return fmt.Errorf("expiring lock without TTL")
+	}
+	return nil
+}
+
+// lock locks the free key, it must be called with the engine locked
+func (m *Memory) lock(key string, opts LockOptions) Lease {
+	owner := opts.Owner
+	if owner == "" {
+		owner = randomOwner()
+	}
+	if m.tokens == nil {
+		m.tokens = map[string]uint64{}
+	}
+	m.tokens[key]++
+	ml := &memoryLock{
+		owner:    owner,
+		priority: opts.Priority,
+		token:    m.tokens[key],
+		lost:     make(chan struct{}),
+		yield:    make(chan struct{}),
+	}
+	var heartbeat time.Duration
+	if opts.Expire {
+		ttl := opts.TTL
+		if ttl == 0 {
+			ttl = m.TTL
+		}
+		m.expireIn(key, ml, ttl)
+	} else if opts.Heartbeat > 0 {
+		// Held while the holder heartbeats
+		heartbeat = opts.Heartbeat
+		ml.heartbeat = true
+		m.expireIn(key, ml, heartbeat)
+	}
+
+	if m.locks == nil {
+		m.locks = map[string]*memoryLock{}
+	}
+	m.locks[key] = ml
+	m.logger(key, owner).Debug("lock acquired")
+	m.emit(audit.Acquired, key, ml)
+
+	return &memoryLease{m: m, key: key, lock: ml, expire: ml.expire, lost: ml.lost, yield: ml.yield, token: ml.token, heartbeat: heartbeat}
+}
+
+// preempt asks the holder of the key to yield if it has lower priority, it
+// must be called with the engine locked
+func (m *Memory) preempt(key string, priority int) {
+	ml, ok := m.locks[key]
+	if ok && !ml.yielded && ml.priority < priority {
+		ml.yielded = true
+		close(ml.yield)
+		m.logger(key, ml.owner).Info("lock holder asked to yield to a higher priority waiter")
+	}
+}
+
+// dequeue removes the waiter from the queue of the key, returns false if it
+// was not queued anymore. It must be called with the engine locked
+func (m *Memory) dequeue(key string, w *memoryWaiter) bool {
+	q := m.queues[key]
+	for i, qw := range q {
+		if qw == w {
+			q = append(q[:i], q[i+1:]...)
+			if len(q) == 0 {
+				delete(m.queues, key)
+			} else {
+				m.queues[key] = q
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Locked satisfies Engine interface
+func (m *Memory) Locked(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.locks[key]
+	return ok, nil
+}
+
+// Keys satisfies Lister interface
+func (m *Memory) Keys(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []string{}
+	for key := range m.locks {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Holder satisfies Inspector interface
+func (m *Memory) Holder(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ml, ok := m.locks[key]; ok {
+		return ml.owner, nil
+	}
+	return "", nil
+}
+
+// Describe satisfies Describer interface
+func (m *Memory) Describe(ctx context.Context, key string) (*LockInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ml, ok := m.locks[key]
+	if !ok {
+		return nil, nil
+	}
+	info := &LockInfo{Holder: ml.owner, Token: ml.token}
+	if ml.timer != nil {
+		info.TTL = ml.expire.Sub(m.clock().Now())
+	}
+	return info, nil
+}
+
+// Wait satisfies Engine interface
+func (m *Memory) Wait(ctx context.Context, key string) <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := make(chan struct{})
+	if _, ok := m.locks[key]; !ok {
+		close(w)
+		return w
+	}
+	if m.waiters == nil {
+		m.waiters = map[string][]chan struct{}{}
+	}
+	m.waiters[key] = append(m.waiters[key], w)
+
+	// Forget the waiter when it gives up
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				m.removeWaiter(key, w)
+			case <-w:
+			}
+		}()
+	}
+	return w
+}
+
+// removeWaiter removes the waiter of the key if it's still waiting
+func (m *Memory) removeWaiter(key string, w chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ws := m.waiters[key]
+	for i, ww := range ws {
+		if ww == w {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(m.waiters, key)
+		return
+	}
+	m.waiters[key] = ws
+}
+
+// Close satisfies Engine interface, all the locks live in the engine so all
+// of them are released and the waiters woken up
+func (m *Memory) Close(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for key, ml := range m.locks {
+		m.emit(audit.Released, key, ml)
+		close(ml.lost)
+		m.release(key)
+	}
+	return nil
+}
+
+// expireIn makes the lock expire in d, it must be called with the engine
+// locked
+func (m *Memory) expireIn(key string, ml *memoryLock, d time.Duration) {
+	if ml.timer != nil {
+		ml.timer.Stop()
+	}
+	ml.expire = m.clock().Now().Add(d)
+	ml.timer = m.clock().AfterFunc(d, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.locks[key] == ml {
+			_, span := m.tracer().Start(context.Background(), "warlock.engine.expire",
+				tracing.String(tracing.KeyAttr, key),
+				tracing.String(tracing.EngineAttr, m.Name()),
+				tracing.Int64(tracing.TokenAttr, int64(ml.token)),
+				tracing.String(tracing.OutcomeAttr, "lost"),
+			)
+			defer span.End()
+			if ml.heartbeat {
+				m.logger(key, ml.owner).Warn("lock holder stopped heartbeating, lock lost")
+			} else {
+				m.logger(key, ml.owner).Warn("lock lost, expired")
+			}
+			m.recorder().IncLockLost(m.Name(), key)
+			m.emit(audit.Expired, key, ml)
+			close(ml.lost)
+			m.release(key)
+		}
+	})
+}
+
+// emit emits the audit event of a transition of the lock, it must be called
+// with the engine locked
+func (m *Memory) emit(t audit.EventType, key string, ml *memoryLock) {
+	if m.Audit == nil {
+		return
+	}
+	m.Audit.Emit(audit.Event{
+		Type:   t,
+		Time:   m.clock().Now(),
+		Engine: m.Name(),
+		Key:    key,
+		Owner:  ml.owner,
+		Token:  ml.token,
+	})
+}
+
+// clock returns the clock, the system one if not set
+func (m *Memory) clock() clock.Clock {
+	if m.Clock == nil {
+		return clock.System
+	}
+	return m.Clock
+}
+
+// recorder returns the metrics recorder, the dummy one if not set
+func (m *Memory) recorder() metrics.Recorder {
+	if m.Metrics == nil {
+		return metrics.Dummy
+	}
+	return m.Metrics
+}
+
+// tracer returns the tracer, the dummy one if not set
+func (m *Memory) tracer() tracing.Tracer {
+	if m.Tracer == nil {
+		return tracing.Dummy
+	}
+	return m.Tracer
+}
+
+// logger returns the logger with the engine, key and owner fields set, the
+// dummy one if not set
+func (m *Memory) logger(key, owner string) log.Logger {
+	if m.Logger == nil {
+		return log.Dummy
+	}
+	return m.Logger.With(log.F(log.EngineField, m.Name()), log.F(log.KeyField, key), log.F(log.OwnerField, owner))
+}
+
+// release removes the lock of the key, wakes up the waiters and grants the
+// lock to the first queued waiter, it must be called with the engine locked
+func (m *Memory) release(key string) {
+	if ml, ok := m.locks[key]; ok && ml.timer != nil {
+		ml.timer.Stop()
+	}
+	delete(m.locks, key)
+	for _, w := range m.waiters[key] {
+		close(w)
+	}
+	delete(m.waiters, key)
+
+	q := m.queues[key]
+	if len(q) == 0 {
+		return
+	}
+	if m.closed {
+		for _, w := range q {
+			w.granted <- memoryGrant{err: NewError(m.Name(), key, ErrClosed)}
+		}
+		delete(m.queues, key)
+		return
+	}
+	w := q[0]
+	m.dequeue(key, w)
+	w.granted <- memoryGrant{lease: m.lock(key, w.opts)}
+}
+
+// memoryLease is a lock held with the memory engine
+type memoryLease struct {
+	m         *Memory
+	key       string
+	lock      *memoryLock
+	expire    time.Time
+	lost      chan struct{}
+	yield     chan struct{}
+	token     uint64
+	heartbeat time.Duration
+	released  bool
+}
+
+// Key satisfies Lease interface
+func (l *memoryLease) Key() string {
+	return l.key
+}
+
+// Unlock satisfies Lease interface
+func (l *memoryLease) Unlock(ctx context.Context) error {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	ml, err := l.check()
+	if err != nil {
+		return err
+	}
+	l.m.emit(audit.Released, l.key, ml)
+	l.m.release(l.key)
+	l.released = true
+	return nil
+}
+
+// Lost satisfies Lease interface
+func (l *memoryLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Yield satisfies Lease interface
+func (l *memoryLease) Yield() <-chan struct{} {
+	return l.yield
+}
+
+// Token satisfies Lease interface
+func (l *memoryLease) Token() uint64 {
+	return l.token
+}
+
+// Heartbeat satisfies Lease interface, the locks with heartbeat expire if the
+// holder doesn't heartbeat within the window
+func (l *memoryLease) Heartbeat() {
+	if l.heartbeat == 0 {
+		return
+	}
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	if ml, err := l.check(); err == nil {
+		l.m.expireIn(l.key, ml, l.heartbeat)
+		l.expire = ml.expire
+	}
+}
+
+// Extend satisfies Lease interface, the locks that don't expire are kept
+// without expiration
+func (l *memoryLease) Extend(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return NewError(l.m.Name(), l.key, fmt.Errorf("non-positive extension: %s", d))
+	}
+
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	ml, err := l.check()
+	if err != nil {
+		return err
+	}
+	if ml.timer != nil {
+		l.m.expireIn(l.key, ml, d)
+		l.expire = ml.expire
+	}
+	return nil
+}
+
+// SetTTL satisfies Lease interface, the locks that don't expire are kept
+// without expiration
+func (l *memoryLease) SetTTL(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return NewError(l.m.Name(), l.key, fmt.Errorf("non-positive TTL: %s", ttl))
+	}
+
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	ml, err := l.check()
+	if err != nil {
+		return err
+	}
+	if ml.timer != nil {
+		l.m.expireIn(l.key, ml, ttl)
+		l.expire = ml.expire
+	}
+	return nil
+}
+
+// check checks the lock is still held by the lease and returns it, it must be
+// called with the engine locked. The lock is compared and not the owner, the
+// same owner could have locked again after the lease lock expired
+func (l *memoryLease) check() (*memoryLock, error) {
+	if l.released {
+		return nil, NewError(l.m.Name(), l.key, ErrNotLocked)
+	}
+	ml, ok := l.m.locks[l.key]
+	if !ok || ml != l.lock {
+		if !l.expire.IsZero() && !l.m.clock().Now().Before(l.expire) {
+			if ok {
+				return nil, NewError(l.m.Name(), l.key, ErrNotOwner)
+			}
+			return nil, NewError(l.m.Name(), l.key, ErrLeaseLost)
+		}
+		return nil, NewError(l.m.Name(), l.key, ErrNotLocked)
+	}
+	return ml, nil
+}
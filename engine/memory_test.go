@@ -0,0 +1,259 @@
+package engine_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/audit"
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/engine"
+	"github.com/slok/warlock/enginetest"
+	"github.com/slok/warlock/log"
+	"github.com/slok/warlock/metrics"
+	"github.com/slok/warlock/tracing"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	m := &engine.Memory{TTL: enginetest.TTL}
+	enginetest.Run(t, func() engine.Engine {
+		return m
+	})
+}
+
+func TestMemoryExpire(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	m := &engine.Memory{Clock: clk}
+	l, err := m.Lock(context.Background(), "key", engine.LockOptions{TTL: time.Minute, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	w := m.Wait(context.Background(), "key")
+
+	clk.Add(time.Minute - time.Nanosecond)
+	if locked, _ := m.Locked(context.Background(), "key"); !locked {
+		t.Errorf("Key should be locked before the TTL, it wasn't")
+	}
+	select {
+	case <-w:
+		t.Errorf("The unlock signal shouldn't be received before the TTL, it did")
+	default:
+	}
+
+	clk.Add(time.Nanosecond)
+	if locked, _ := m.Locked(context.Background(), "key"); locked {
+		t.Errorf("Key shouldn't be locked after the TTL, it was")
+	}
+	select {
+	case <-w:
+	default:
+		t.Errorf("The unlock signal should be received after the TTL, it wasn't")
+	}
+	if err := l.Unlock(context.Background()); !errors.Is(err, engine.ErrLeaseLost) {
+		t.Errorf("Unlock should return a lease lost error, it didn't: %v", err)
+	}
+}
+
+func TestMemoryExpireWithoutTTL(t *testing.T) {
+	m := &engine.Memory{}
+	opts := engine.LockOptions{Expire: true}
+	if _, err := m.Lock(context.Background(), "key", opts); err == nil {
+		t.Errorf("Lock should return an error expiring without TTL, it didn't")
+	}
+	if _, err := m.Acquire(context.Background(), "key", opts); err == nil {
+		t.Errorf("Acquire should return an error expiring without TTL, it didn't")
+	}
+	if locked, _ := m.Locked(context.Background(), "key"); locked {
+		t.Errorf("Key shouldn't be locked, it was")
+	}
+}
+
+func TestMemoryFromURL(t *testing.T) {
+	tests := []struct {
+		dsn    string
+		expTTL time.Duration
+		expErr bool
+	}{
+		{"memory://", engine.DefaultMemoryTTL, false},
+		{"memory://?ttl=5s", 5 * time.Second, false},
+		{"memory://?ttl=wrong", 0, true},
+	}
+
+	for _, test := range tests {
+		e, err := engine.Open(test.dsn)
+		if test.expErr {
+			if err == nil {
+				t.Errorf("%s: Open should return an error, it didn't", test.dsn)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Open shouldn't return an error: %v", test.dsn, err)
+		}
+		if m, ok := e.(*engine.Memory); !ok || m.TTL != test.expTTL {
+			t.Errorf("%s: engine should be a memory engine with TTL %s; got %+v", test.dsn, test.expTTL, e)
+		}
+	}
+}
+
+func TestMemoryStaleLeaseSameOwner(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	m := &engine.Memory{Clock: clk}
+	opts := engine.LockOptions{Owner: "owner1", TTL: time.Minute, Expire: true}
+	stale, err := m.Lock(context.Background(), "key", opts)
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	clk.Add(time.Minute)
+	l, err := m.Lock(context.Background(), "key", opts)
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	// The expired lease can't touch the new lock of the same owner
+	if err := stale.Extend(context.Background(), time.Hour); !errors.Is(err, engine.ErrNotOwner) {
+		t.Errorf("Extend should return a not owner error, it didn't: %v", err)
+	}
+	if err := stale.SetTTL(context.Background(), time.Hour); !errors.Is(err, engine.ErrNotOwner) {
+		t.Errorf("SetTTL should return a not owner error, it didn't: %v", err)
+	}
+	if err := stale.Unlock(context.Background()); !errors.Is(err, engine.ErrNotOwner) {
+		t.Errorf("Unlock should return a not owner error, it didn't: %v", err)
+	}
+	if locked, _ := m.Locked(context.Background(), "key"); !locked {
+		t.Errorf("Key should be locked by the new lease, it wasn't")
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Errorf("Unlock shouldn't return an error: %v", err)
+	}
+}
+
+func TestNamespaceConformance(t *testing.T) {
+	m := &engine.Memory{TTL: enginetest.TTL}
+	enginetest.Run(t, func() engine.Engine {
+		return &engine.Namespace{Engine: m, Prefix: "app"}
+	})
+}
+
+func TestHierarchyConformance(t *testing.T) {
+	m := &engine.Memory{TTL: enginetest.TTL}
+	enginetest.Run(t, func() engine.Engine {
+		return &engine.Hierarchy{Engine: m}
+	})
+}
+
+func TestIntentionConformance(t *testing.T) {
+	m := &engine.Memory{TTL: enginetest.TTL}
+	enginetest.Run(t, func() engine.Engine {
+		return &engine.Intention{Engine: m}
+	})
+}
+
+func TestMemoryAudit(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	h := &audit.Hub{}
+	events, unsubscribe := h.Subscribe(10)
+	defer unsubscribe()
+	m := &engine.Memory{Clock: clk, Audit: h}
+	if _, err := m.Lock(context.Background(), "key", engine.LockOptions{Owner: "owner1", TTL: time.Minute, Expire: true}); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	clk.Add(time.Minute)
+	l, err := m.Lock(context.Background(), "key", engine.LockOptions{Owner: "owner2"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	// The locks released on close are audited too
+	if _, err := m.Lock(context.Background(), "key", engine.LockOptions{Owner: "owner3"}); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatalf("Close shouldn't return an error: %v", err)
+	}
+
+	tests := []struct {
+		typ   audit.EventType
+		owner string
+		token uint64
+	}{
+		{audit.Acquired, "owner1", 1},
+		{audit.Expired, "owner1", 1},
+		{audit.Acquired, "owner2", 2},
+		{audit.Released, "owner2", 2},
+		{audit.Acquired, "owner3", 3},
+		{audit.Released, "owner3", 3},
+	}
+	for _, test := range tests {
+		select {
+		case e := <-events:
+			if e.Type != test.typ || e.Engine != m.Name() || e.Key != "key" || e.Owner != test.owner || e.Token != test.token {
+				t.Errorf("The %s event of %s with token %d should be emitted; got %+v", test.typ, test.owner, test.token, e)
+			}
+		default:
+			t.Errorf("The %s event should be emitted, it wasn't", test.typ)
+		}
+	}
+}
+
+func TestMemoryInstrumentation(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	b := &bytes.Buffer{}
+	rec := metrics.NewPrometheus()
+	tr := &tracing.Memory{}
+	m := &engine.Memory{
+		Clock:   clk,
+		Metrics: rec,
+		Tracer:  tr,
+		Logger:  log.NewSlog(slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+	}
+	if _, err := m.Lock(context.Background(), "key", engine.LockOptions{Owner: "owner1", Heartbeat: time.Second}); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	clk.Add(time.Second)
+
+	expected := []struct {
+		msg   string
+		level string
+	}{
+		{"lock acquired", "DEBUG"},
+		{"lock holder stopped heartbeating, lock lost", "WARN"},
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Wrong number of log messages, expected %d; got %d: %s", len(expected), len(lines), b.String())
+	}
+	for i, exp := range expected {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("Log message should be JSON: %v", err)
+		}
+		if entry["msg"] != exp.msg || entry["level"] != exp.level {
+			t.Errorf("Log message should be %q at %s; got %v", exp.msg, exp.level, entry)
+		}
+		if entry[log.KeyField] != "key" || entry[log.EngineField] != m.Name() || entry[log.OwnerField] != "owner1" {
+			t.Errorf("Log message should be set with the key, engine and owner: %v", entry)
+		}
+	}
+
+	mb := &bytes.Buffer{}
+	rec.WriteTo(mb)
+	if exp := `warlock_lock_lost_total{engine="memory",key="key"} 1`; !strings.Contains(mb.String(), exp) {
+		t.Errorf("Metrics should contain %q; got:\n%s", exp, mb.String())
+	}
+
+	spans := tr.Spans()
+	if len(spans) != 1 || spans[0].Name != "warlock.engine.expire" || !spans[0].Ended {
+		t.Fatalf("An expire span should be traced; got %+v", spans)
+	}
+	if spans[0].Attributes[tracing.OutcomeAttr] != "lost" || spans[0].Attributes[tracing.TokenAttr] != int64(1) {
+		t.Errorf("The expire span should be described with the outcome and token; got %v", spans[0].Attributes)
+	}
+}
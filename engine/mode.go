@@ -0,0 +1,67 @@
+package engine
+
+// Mode is the mode a lock is held with on the engines with intention locks
+// (see Intention), the rest of the engines hold all the locks exclusively
+type Mode int
+
+const (
+	// Exclusive (X) mode, the holder is the only one on the key
+	Exclusive Mode = iota
+
+	// Shared (S) mode, the holders can read the key and its descendants
+	Shared
+
+	// IntentionExclusive (IX) mode, the holders intend to lock descendants of
+	// the key exclusively
+	IntentionExclusive
+
+	// IntentionShared (IS) mode, the holders intend to lock descendants of the
+	// key shared
+	IntentionShared
+)
+
+// compatibility is the compatibility matrix of the modes
+var compatibility = map[Mode]map[Mode]bool{
+	IntentionShared:    {IntentionShared: true, IntentionExclusive: true, Shared: true},
+	IntentionExclusive: {IntentionShared: true, IntentionExclusive: true},
+	Shared:             {IntentionShared: true, Shared: true},
+	Exclusive:          {},
+}
+
+// Compatible returns true if the modes can be held at the same time on a key
+func (m Mode) Compatible(o Mode) bool {
+	return compatibility[m][o]
+}
+
+// Intention returns the mode the ancestors of a key are locked with to lock
+// the key with the mode
+func (m Mode) Intention() Mode {
+	if m == Shared || m == IntentionShared {
+		return IntentionShared
+	}
+	return IntentionExclusive
+}
+
+func (m Mode) String() string {
+	switch m {
+	case Exclusive:
+		return "X"
+	case Shared:
+		return "S"
+	case IntentionExclusive:
+		return "IX"
+	case IntentionShared:
+		return "IS"
+	}
+	return "unknown"
+}
+
+// parseMode parses the mode from its string
+func parseMode(s string) (Mode, bool) {
+	for _, m := range []Mode{Exclusive, Shared, IntentionExclusive, IntentionShared} {
+		if m.String() == s {
+			return m, true
+		}
+	}
+	return 0, false
+}
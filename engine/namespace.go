@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Namespace is an engine that prefixes the keys of the wrapped engine with
+// the namespace, so different applications can share the same backend without
+// colliding. The leases and errors have the keys without the namespace
+type Namespace struct {
+	// Engine is the wrapped engine
+	Engine Engine
+
+	// Prefix is the namespace, the keys are prefixed with "<prefix>/"
+	Prefix string
+}
+
+// Name satisfies Engine interface
+func (n *Namespace) Name() string {
+	return n.Engine.Name()
+}
+
+// Lock satisfies Engine interface
+func (n *Namespace) Lock(ctx context.Context, key string, opts LockOptions) (Lease, error) {
+	l, err := n.Engine.Lock(ctx, n.key(key), opts)
+	if err != nil {
+		return nil, n.err(key, err)
+	}
+	return &namespaceLease{n: n, key: key, l: l}, nil
+}
+
+// Locked satisfies Engine interface
+func (n *Namespace) Locked(ctx context.Context, key string) (bool, error) {
+	locked, err := n.Engine.Locked(ctx, n.key(key))
+	return locked, n.err(key, err)
+}
+
+// Wait satisfies Engine interface
+func (n *Namespace) Wait(ctx context.Context, key string) <-chan struct{} {
+	return n.Engine.Wait(ctx, n.key(key))
+}
+
+// Close satisfies Engine interface
+func (n *Namespace) Close(ctx context.Context) error {
+	return n.Engine.Close(ctx)
+}
+
+// Holder satisfies Inspector interface, it's empty if the wrapped engine is
+// not an inspector
+func (n *Namespace) Holder(ctx context.Context, key string) (string, error) {
+	i, ok := n.Engine.(Inspector)
+	if !ok {
+		return "", nil
+	}
+	holder, err := i.Holder(ctx, n.key(key))
+	return holder, n.err(key, err)
+}
+
+// Acquire satisfies Queuer interface, it fails if the wrapped engine is not a
+// queuer
+func (n *Namespace) Acquire(ctx context.Context, key string, opts LockOptions) (Lease, error) {
+	q, ok := n.Engine.(Queuer)
+	if !ok {
+		return nil, fmt.Errorf("%s engine can't queue the waiters", n.Name())
+	}
+	l, err := q.Acquire(ctx, n.key(key), opts)
+	if err != nil {
+		return nil, n.err(key, err)
+	}
+	return &namespaceLease{n: n, key: key, l: l}, nil
+}
+
+// Describe satisfies Describer interface, it's nil if the wrapped engine is
+// not a describer
+func (n *Namespace) Describe(ctx context.Context, key string) (*LockInfo, error) {
+	d, ok := n.Engine.(Describer)
+	if !ok {
+		return nil, nil
+	}
+	info, err := d.Describe(ctx, n.key(key))
+	return info, n.err(key, err)
+}
+
+// Keys satisfies Lister interface, it fails if the wrapped engine is not a
+// lister
+func (n *Namespace) Keys(ctx context.Context, prefix string) ([]string, error) {
+	l, ok := n.Engine.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("%s engine can't list the keys", n.Name())
+	}
+	keys, err := l.Keys(ctx, n.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, n.key(""))
+	}
+	return keys, nil
+}
+
+// key returns the key with the namespace
+func (n *Namespace) key(key string) string {
+	return n.Prefix + "/" + key
+}
+
+// err replaces the key with the namespace of the engine errors with the key
+func (n *Namespace) err(key string, err error) error {
+	var e *Error
+	if err == nil || !errors.As(err, &e) || e.Key != n.key(key) {
+		return err
+	}
+	return NewError(e.Engine, key, e.Err)
+}
+
+// namespaceLease is a lease of a namespaced key
+type namespaceLease struct {
+	n   *Namespace
+	key string
+	l   Lease
+}
+
+// Key satisfies Lease interface
+func (l *namespaceLease) Key() string {
+	return l.key
+}
+
+// Unlock satisfies Lease interface
+func (l *namespaceLease) Unlock(ctx context.Context) error {
+	return l.n.err(l.key, l.l.Unlock(ctx))
+}
+
+// Extend satisfies Lease interface
+func (l *namespaceLease) Extend(ctx context.Context, d time.Duration) error {
+	return l.n.err(l.key, l.l.Extend(ctx, d))
+}
+
+// SetTTL satisfies Lease interface
+func (l *namespaceLease) SetTTL(ctx context.Context, ttl time.Duration) error {
+	return l.n.err(l.key, l.l.SetTTL(ctx, ttl))
+}
+
+// Lost satisfies Lease interface
+func (l *namespaceLease) Lost() <-chan struct{} {
+	return l.l.Lost()
+}
+
+// Heartbeat satisfies Lease interface
+func (l *namespaceLease) Heartbeat() {
+	l.l.Heartbeat()
+}
+
+// Yield satisfies Lease interface
+func (l *namespaceLease) Yield() <-chan struct{} {
+	return l.l.Yield()
+}
+
+// Token satisfies Lease interface
+func (l *namespaceLease) Token() uint64 {
+	return l.l.Token()
+}
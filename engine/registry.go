@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Factory creates an engine from its configuration URL
+type Factory func(u *url.URL) (Engine, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register registers an engine factory for a URL scheme, engines (built-in
+// and third-party) register themselves on init so they can be opened by
+// configuration. It panics if the scheme is already registered
+func Register(scheme string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if f == nil {
+		panic("engine: register factory is nil")
+	}
+	if _, ok := factories[scheme]; ok {
+		panic(fmt.Sprintf("engine: factory already registered for scheme %s", scheme))
+	}
+	factories[scheme] = f
+}
+
+// Schemes returns the registered schemes sorted
+func Schemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	ss := []string{}
+	for s := range factories {
+		ss = append(ss, s)
+	}
+	sort.Strings(ss)
+	return ss
+}
+
+// Open creates an engine from the configuration URL, the URL scheme selects
+// the engine, for example file:///mnt/locks?ttl=30s. Any engine can be
+// namespaced with namespace=myapp and have hierarchical keys with
+// hierarchy=true
+func Open(dsn string) (Engine, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	factoriesMu.RLock()
+	f, ok := factories[u.Scheme]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown engine %q", u.Scheme)
+	}
+
+	e, err := f(u)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	if ns := q.Get("namespace"); ns != "" {
+		e = &Namespace{Engine: e, Prefix: ns}
+	}
+	if h := q.Get("hierarchy"); h != "" {
+		hierarchy, err := strconv.ParseBool(h)
+		if err != nil {
+			return nil, fmt.Errorf("wrong hierarchy: %s", err)
+		}
+		if hierarchy {
+			e = &Hierarchy{Engine: e}
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// DefaultRenewPolicy is the renewal policy used by the engines when no policy
+// is set: renew at half of the TTL with some jitter and retry the failed
+// renewals 3 times
+var DefaultRenewPolicy = &RenewPolicy{
+	Fraction: 0.5,
+	Jitter:   0.2,
+	Retries:  3,
+}
+
+// RenewPolicy decides when the engines that renew the held locks
+// automatically renew them and when a lock that can't be renewed is lost
+type RenewPolicy struct {
+	// Fraction is the fraction of the TTL between renewals (less than 1), if
+	// zero the locks are renewed at half of the TTL
+	Fraction float64
+
+	// Jitter is the fraction of the intervals and retry waits randomly added
+	// or subtracted so the holders don't renew in lockstep (less than 1),
+	// optional
+	Jitter float64
+
+	// Retries is the number of times a failed renewal is retried before
+	// waiting for the next interval, optional
+	Retries int
+
+	// Backoff is the wait before the first retry, doubled on every retry, if
+	// zero a tenth of the TTL is used
+	Backoff time.Duration
+
+	// Margin is the time before the expiration the lock is considered lost if
+	// it couldn't be renewed, optional
+	Margin time.Duration
+}
+
+// Validate checks the renewals happen before the locks expire, even with the
+// jitter added
+func (p *RenewPolicy) Validate() error {
+	if p.Fraction < 0 || p.Fraction >= 1 {
+		return fmt.Errorf("wrong renewal fraction, it must be in [0, 1): %v", p.Fraction)
+	}
+	if p.Jitter < 0 || p.Jitter >= 1 {
+		return fmt.Errorf("wrong renewal jitter, it must be in [0, 1): %v", p.Jitter)
+	}
+	if f := p.fraction() * (1 + p.Jitter); f >= 1 {
+		return fmt.Errorf("wrong renewal fraction and jitter, the renewals could happen after the expiration: %v", f)
+	}
+	return nil
+}
+
+// Next returns the time until the next renewal of a lock with the TTL
+func (p *RenewPolicy) Next(ttl time.Duration) time.Duration {
+	return p.jitter(time.Duration(float64(ttl) * p.fraction()))
+}
+
+// fraction returns the fraction of the TTL between renewals, half if not set
+func (p *RenewPolicy) fraction() float64 {
+	if p.Fraction <= 0 {
+		return 0.5
+	}
+	return p.Fraction
+}
+
+// RetryWait returns the wait before the retry (starting at 0) of a failed
+// renewal of a lock with the TTL, false if there are no more retries
+func (p *RenewPolicy) RetryWait(retry int, ttl time.Duration) (time.Duration, bool) {
+	if retry >= p.Retries {
+		return 0, false
+	}
+	d := p.Backoff
+	if d <= 0 {
+		d = ttl / 10
+	}
+	return p.jitter(d << uint(retry)), true
+}
+
+// Deadline returns the time a lock expiring at expire is lost if it's not
+// renewed
+func (p *RenewPolicy) Deadline(expire time.Time) time.Time {
+	return expire.Add(-p.Margin)
+}
+
+// jitter randomizes the duration by the jitter fraction
+func (p *RenewPolicy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return d
+	}
+	j := time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	return d + j
+}
@@ -0,0 +1,86 @@
+package engine_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/engine"
+)
+
+func TestRenewPolicyNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy engine.RenewPolicy
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"default fraction", engine.RenewPolicy{}, 5 * time.Second, 5 * time.Second},
+		{"fraction", engine.RenewPolicy{Fraction: 0.25}, 2500 * time.Millisecond, 2500 * time.Millisecond},
+		{"jitter", engine.RenewPolicy{Jitter: 0.2}, 4 * time.Second, 6 * time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			d := test.policy.Next(10 * time.Second)
+			if d < test.min || d > test.max {
+				t.Errorf("%s: next renewal should be between %s and %s; got %s", test.name, test.min, test.max, d)
+				break
+			}
+		}
+	}
+}
+
+func TestRenewPolicyRetryWait(t *testing.T) {
+	p := engine.RenewPolicy{Retries: 3, Backoff: time.Second}
+	for i, exp := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		d, ok := p.RetryWait(i, 10*time.Second)
+		if !ok || d != exp {
+			t.Errorf("Retry %d should wait %s; got %s (%t)", i, exp, d, ok)
+		}
+	}
+	if _, ok := p.RetryWait(3, 10*time.Second); ok {
+		t.Errorf("There shouldn't be more retries")
+	}
+
+	// Without backoff a tenth of the TTL is used
+	p = engine.RenewPolicy{Retries: 1}
+	if d, _ := p.RetryWait(0, 10*time.Second); d != time.Second {
+		t.Errorf("Retry should wait 1s; got %s", d)
+	}
+}
+
+func TestRenewPolicyDeadline(t *testing.T) {
+	p := engine.RenewPolicy{Margin: time.Second}
+	expire := time.Now()
+	if d := p.Deadline(expire); !d.Equal(expire.Add(-time.Second)) {
+		t.Errorf("Deadline should be the margin before the expiration; got %s", expire.Sub(d))
+	}
+}
+
+func TestRenewPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy engine.RenewPolicy
+		valid  bool
+	}{
+		{"default", *engine.DefaultRenewPolicy, true},
+		{"empty", engine.RenewPolicy{}, true},
+		{"high fraction", engine.RenewPolicy{Fraction: 0.9}, true},
+		{"negative fraction", engine.RenewPolicy{Fraction: -0.5}, false},
+		{"fraction at expiration", engine.RenewPolicy{Fraction: 1}, false},
+		{"fraction past expiration", engine.RenewPolicy{Fraction: 1.5}, false},
+		{"negative jitter", engine.RenewPolicy{Jitter: -0.2}, false},
+		{"full jitter", engine.RenewPolicy{Jitter: 1}, false},
+		{"jitter past expiration", engine.RenewPolicy{Fraction: 0.9, Jitter: 0.2}, false},
+	}
+
+	for _, test := range tests {
+		err := test.policy.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: policy should be valid: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: policy shouldn't be valid, it was", test.name)
+		}
+	}
+}
@@ -0,0 +1,732 @@
+// Package enginetest has the behavioural test suite that every engine must
+// pass, built-in and third-party engines can run it from their tests:
+//
+//	func TestConformance(t *testing.T) {
+//		enginetest.Run(t, func() engine.Engine {
+//			return &myengine.Engine{...}
+//		})
+//	}
+package enginetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slok/warlock/engine"
+)
+
+const (
+	// TTL is the TTL used on the expiring locks of the suite, the engines
+	// should be configured with this TTL as the default one so the waits
+	// based on it are fast
+	TTL = 50 * time.Millisecond
+
+	// waitTimeout is the max time a waiter can take to be woken up
+	waitTimeout = 10 * TTL
+)
+
+// Factory returns the engine to test, all the engines returned by the same
+// factory must share the same backend (like different processes using the
+// same locks). The factory is called at least once per test and the keys
+// are unique per test
+type Factory func() engine.Engine
+
+// Run runs the engine conformance suite
+func Run(t *testing.T, newEngine Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, newEngine Factory, key string)
+	}{
+		{"LockUnlock", testLockUnlock},
+		{"LockLocked", testLockLocked},
+		{"MultipleKeys", testMultipleKeys},
+		{"MutualExclusion", testMutualExclusion},
+		{"Expire", testExpire},
+		{"Renewal", testRenewal},
+		{"UnlockTwice", testUnlockTwice},
+		{"UnlockExpired", testUnlockExpired},
+		{"UnlockNotOwner", testUnlockNotOwner},
+		{"Extend", testExtend},
+		{"SetTTL", testSetTTL},
+		{"ExtendInvalid", testExtendInvalid},
+		{"ExtendExpired", testExtendExpired},
+		{"Heartbeat", testHeartbeat},
+		{"HeartbeatStopped", testHeartbeatStopped},
+		{"Holder", testHolder},
+		{"Describe", testDescribe},
+		{"Fencing", testFencing},
+		{"LostExpire", testLostExpire},
+		{"LostUnlock", testLostUnlock},
+		{"WaitRelease", testWaitRelease},
+		{"WaitExpire", testWaitExpire},
+		{"WaitConcurrent", testWaitConcurrent},
+		{"WaitCancelled", testWaitCancelled},
+		{"LockCancelled", testLockCancelled},
+		{"QueuePriority", testQueuePriority},
+		{"QueuePreempt", testQueuePreempt},
+		{"QueueCancelled", testQueueCancelled},
+		// Closing can close the shared backend (like the memory engine) so
+		// it's the last one
+		{"Close", testClose},
+	}
+
+	for i, test := range tests {
+		key := fmt.Sprintf("enginetest-%d-%d", time.Now().UnixNano(), i)
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newEngine, key)
+		})
+	}
+}
+
+// checkError checks the error is an engine error of the key wrapping the
+// expected error
+func checkError(t *testing.T, e engine.Engine, key string, err, expErr error) {
+	t.Helper()
+	if !errors.Is(err, expErr) {
+		t.Errorf("Expected error %q; got %v", expErr, err)
+		return
+	}
+	var engErr *engine.Error
+	if !errors.As(err, &engErr) {
+		t.Errorf("Error should be an engine error, it wasn't: %T", err)
+		return
+	}
+	if engErr.Engine != e.Name() || engErr.Key != key {
+		t.Errorf("Error should have the engine %s and key %s, it had: %s and %s", e.Name(), key, engErr.Engine, engErr.Key)
+	}
+}
+
+func checkLocked(t *testing.T, e engine.Engine, key string, expLocked bool) {
+	t.Helper()
+	locked, err := e.Locked(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Locked shouldn't return an error: %v", err)
+	}
+	if locked != expLocked {
+		t.Errorf("Locked should be %t; got %t", expLocked, locked)
+	}
+}
+
+func testLockUnlock(t *testing.T, newEngine Factory, key string) {
+	e := newEngine()
+	checkLocked(t, e, key, false)
+
+	l, err := e.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if l.Key() != key {
+		t.Errorf("Lease key should be %s; got %s", key, l.Key())
+	}
+	checkLocked(t, e, key, true)
+
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	checkLocked(t, e, key, false)
+}
+
+func testLockLocked(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+
+	_, err = e2.Lock(context.Background(), key, engine.LockOptions{})
+	checkError(t, e2, key, err, engine.ErrLocked)
+	_, err = e1.Lock(context.Background(), key, engine.LockOptions{})
+	checkError(t, e1, key, err, engine.ErrLocked)
+	checkLocked(t, e2, key, true)
+}
+
+func testMultipleKeys(t *testing.T, newEngine Factory, key string) {
+	e := newEngine()
+	for i := 0; i < 10; i++ {
+		l, err := e.Lock(context.Background(), fmt.Sprintf("%s-%d", key, i), engine.LockOptions{})
+		if err != nil {
+			t.Fatalf("Lock shouldn't return an error: %v", err)
+		}
+		defer l.Unlock(context.Background())
+	}
+	checkLocked(t, e, key, false)
+}
+
+func testMutualExclusion(t *testing.T, newEngine Factory, key string) {
+	const workers = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		acquired []engine.Lease
+		start    = make(chan struct{})
+	)
+	for i := 0; i < workers; i++ {
+		e := newEngine()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			l, err := e.Lock(context.Background(), key, engine.LockOptions{})
+			if err != nil {
+				if !errors.Is(err, engine.ErrLocked) {
+					t.Errorf("Lock should return a locked error, it didn't: %v", err)
+				}
+				return
+			}
+			mu.Lock()
+			acquired = append(acquired, l)
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if len(acquired) != 1 {
+		t.Errorf("Only one lock should be acquired; got %d", len(acquired))
+	}
+	for _, l := range acquired {
+		l.Unlock(context.Background())
+	}
+}
+
+func testExpire(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	_, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	checkLocked(t, e2, key, true)
+
+	time.Sleep(TTL * 2)
+	checkLocked(t, e2, key, false)
+	l, err := e2.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	l.Unlock(context.Background())
+}
+
+func testRenewal(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+
+	time.Sleep(TTL * 3)
+	checkLocked(t, e2, key, true)
+	_, err = e2.Lock(context.Background(), key, engine.LockOptions{})
+	checkError(t, e2, key, err, engine.ErrLocked)
+}
+
+func testUnlockTwice(t *testing.T, newEngine Factory, key string) {
+	e := newEngine()
+	l, err := e.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	err = l.Unlock(context.Background())
+	checkError(t, e, key, err, engine.ErrNotLocked)
+}
+
+func testUnlockExpired(t *testing.T, newEngine Factory, key string) {
+	e := newEngine()
+	l, err := e.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	time.Sleep(TTL * 2)
+	err = l.Unlock(context.Background())
+	checkError(t, e, key, err, engine.ErrLeaseLost)
+}
+
+func testUnlockNotOwner(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l1, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true, Owner: "owner1"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	time.Sleep(TTL * 2)
+	l2, err := e2.Lock(context.Background(), key, engine.LockOptions{Owner: "owner2"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l2.Unlock(context.Background())
+
+	err = l1.Unlock(context.Background())
+	checkError(t, e1, key, err, engine.ErrNotOwner)
+	checkLocked(t, e1, key, true)
+}
+
+func testExtend(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l.Extend(context.Background(), 4*TTL); err != nil {
+		t.Fatalf("Extend shouldn't return an error: %v", err)
+	}
+
+	time.Sleep(TTL * 2)
+	checkLocked(t, e2, key, true)
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Errorf("Unlock shouldn't return an error: %v", err)
+	}
+}
+
+func testSetTTL(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l.SetTTL(context.Background(), 4*TTL); err != nil {
+		t.Fatalf("SetTTL shouldn't return an error: %v", err)
+	}
+
+	time.Sleep(TTL * 2)
+	checkLocked(t, e2, key, true)
+	time.Sleep(TTL * 3)
+	checkLocked(t, e2, key, false)
+}
+
+func testExtendInvalid(t *testing.T, newEngine Factory, key string) {
+	e := newEngine()
+	l, err := e.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+
+	if err := l.Extend(context.Background(), 0); err == nil {
+		t.Errorf("Extend with a non-positive duration should return an error, it didn't")
+	}
+	if err := l.SetTTL(context.Background(), -TTL); err == nil {
+		t.Errorf("SetTTL with a non-positive TTL should return an error, it didn't")
+	}
+}
+
+func testExtendExpired(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l1, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	time.Sleep(TTL * 2)
+	l2, err := e2.Lock(context.Background(), key, engine.LockOptions{Owner: "owner2"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l2.Unlock(context.Background())
+
+	err = l1.Extend(context.Background(), TTL)
+	checkError(t, e1, key, err, engine.ErrNotOwner)
+	err = l1.SetTTL(context.Background(), TTL)
+	checkError(t, e1, key, err, engine.ErrNotOwner)
+	checkLocked(t, e2, key, true)
+}
+
+func testLostExpire(t *testing.T, newEngine Factory, key string) {
+	e := newEngine()
+	l, err := e.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	select {
+	case <-l.Lost():
+	case <-time.After(waitTimeout):
+		t.Errorf("The lost signal should be received when the lock expires, it didn't")
+	}
+}
+
+func testLostUnlock(t *testing.T, newEngine Factory, key string) {
+	e := newEngine()
+	l, err := e.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+
+	select {
+	case <-l.Lost():
+		t.Errorf("The lost signal shouldn't be received after unlocking, it did")
+	case <-time.After(TTL * 2):
+	}
+}
+
+func testHeartbeat(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Heartbeat: TTL})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+
+	for i := 0; i < 16; i++ {
+		time.Sleep(TTL / 4)
+		l.Heartbeat()
+	}
+	checkLocked(t, e2, key, true)
+	select {
+	case <-l.Lost():
+		t.Errorf("The lost signal shouldn't be received while heartbeating, it did")
+	default:
+	}
+}
+
+func testHeartbeatStopped(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Heartbeat: TTL})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	select {
+	case <-l.Lost():
+	case <-time.After(waitTimeout):
+		t.Fatalf("The lost signal should be received when the holder stops heartbeating, it didn't")
+	}
+	select {
+	case <-e2.Wait(context.Background(), key):
+	case <-time.After(waitTimeout):
+		t.Errorf("The lock should be released when the holder stops heartbeating, it wasn't")
+	}
+}
+
+func testHolder(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	i, ok := e2.(engine.Inspector)
+	if !ok {
+		t.Skip("The engine can't tell the lock holders")
+	}
+	if holder, err := i.Holder(context.Background(), key); err != nil || holder != "" {
+		t.Errorf("Holder should be empty when not locked; got %q (%v)", holder, err)
+	}
+
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{Owner: "owner1"})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+	if holder, err := i.Holder(context.Background(), key); err != nil || holder != "owner1" {
+		t.Errorf("Holder should be owner1; got %q (%v)", holder, err)
+	}
+}
+
+func testDescribe(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	d, ok := e2.(engine.Describer)
+	if !ok {
+		t.Skip("The engine can't describe the locks")
+	}
+	if info, err := d.Describe(context.Background(), key); err != nil || info != nil {
+		t.Errorf("Describe should be nil when not locked; got %v (%v)", info, err)
+	}
+
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{Owner: "owner1", TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+	info, err := d.Describe(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Describe shouldn't return an error: %v", err)
+	}
+	if info == nil || info.Holder != "owner1" {
+		t.Fatalf("Describe should tell the holder owner1; got %v", info)
+	}
+	if info.TTL <= 0 || info.TTL > TTL {
+		t.Errorf("Describe TTL should be within (0, %s]; got %s", TTL, info.TTL)
+	}
+	if info.Token != l.Token() {
+		t.Errorf("Describe token should be the lease token %d; got %d", l.Token(), info.Token)
+	}
+}
+
+func testFencing(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	var tokens []uint64
+	for _, e := range []engine.Engine{e1, e2, e1} {
+		l, err := e.Lock(context.Background(), key, engine.LockOptions{})
+		if err != nil {
+			t.Fatalf("Lock shouldn't return an error: %v", err)
+		}
+		tokens = append(tokens, l.Token())
+		if err := l.Unlock(context.Background()); err != nil {
+			t.Fatalf("Unlock shouldn't return an error: %v", err)
+		}
+	}
+	if tokens[0] == 0 {
+		t.Skip("The engine doesn't support fencing")
+	}
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i] <= tokens[i-1] {
+			t.Errorf("The fencing tokens should increase; got %v", tokens)
+		}
+	}
+}
+
+func testWaitRelease(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	w := e2.Wait(context.Background(), key)
+	select {
+	case <-w:
+		t.Fatalf("The unlock signal shouldn't be received while locked, it did")
+	case <-time.After(TTL):
+	}
+
+	l.Unlock(context.Background())
+	select {
+	case <-w:
+	case <-time.After(waitTimeout):
+		t.Errorf("The unlock signal should be received, it didn't")
+	}
+}
+
+func testWaitExpire(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	_, err := e1.Lock(context.Background(), key, engine.LockOptions{TTL: TTL, Expire: true})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	select {
+	case <-e2.Wait(context.Background(), key):
+	case <-time.After(waitTimeout):
+		t.Errorf("The unlock signal should be received when the lock expires, it didn't")
+	}
+}
+
+func testWaitConcurrent(t *testing.T, newEngine Factory, key string) {
+	const waiters = 50
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	ws := make([]<-chan struct{}, waiters)
+	var wg sync.WaitGroup
+	for i := range ws {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ws[i] = e2.Wait(context.Background(), key)
+		}(i)
+	}
+	wg.Wait()
+
+	l.Unlock(context.Background())
+	timeout := time.After(waitTimeout)
+	for i, w := range ws {
+		select {
+		case <-w:
+		case <-timeout:
+			t.Fatalf("The unlock signal should be received by waiter %d, it didn't", i)
+		}
+	}
+}
+
+func testWaitCancelled(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	defer l.Unlock(context.Background())
+
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := e2.Wait(ctx, key)
+	cancel()
+
+	select {
+	case <-w:
+		t.Errorf("The unlock signal shouldn't be received after cancelling the wait, it did")
+	case <-time.After(TTL):
+	}
+
+	// The engine shouldn't leave anything running for the cancelled waiter
+	deadline := time.Now().Add(waitTimeout)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(TTL / 10)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("Cancelled waiter leaked goroutines: %d before, %d after", goroutines, n)
+	}
+}
+
+func testLockCancelled(t *testing.T, newEngine Factory, key string) {
+	e := newEngine()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := e.Lock(ctx, key, engine.LockOptions{})
+	checkError(t, e, key, err, context.Canceled)
+	checkLocked(t, e, key, false)
+}
+
+func testQueuePriority(t *testing.T, newEngine Factory, key string) {
+	e1 := newEngine()
+	if _, ok := e1.(engine.Queuer); !ok {
+		t.Skip("The engine doesn't queue the waiters")
+	}
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	// Queue the waiters one by one so their arrival order is known
+	waiters := []struct {
+		name     string
+		priority int
+	}{
+		{"low1", 1},
+		{"high", 5},
+		{"low2", 1},
+	}
+	granted := make(chan string, len(waiters))
+	var wg sync.WaitGroup
+	for _, w := range waiters {
+		wg.Add(1)
+		go func(name string, priority int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+			defer cancel()
+			l, err := newEngine().(engine.Queuer).Acquire(ctx, key, engine.LockOptions{Priority: priority})
+			if err != nil {
+				t.Errorf("Acquire shouldn't return an error: %v", err)
+				return
+			}
+			granted <- name
+			l.Unlock(context.Background())
+		}(w.name, w.priority)
+		time.Sleep(TTL / 2)
+	}
+
+	l.Unlock(context.Background())
+	wg.Wait()
+	close(granted)
+	exp := []string{"high", "low1", "low2"}
+	i := 0
+	for name := range granted {
+		if i < len(exp) && name != exp[i] {
+			t.Errorf("Waiter %d granted should be %s; got %s", i, exp[i], name)
+		}
+		i++
+	}
+}
+
+func testQueuePreempt(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	q, ok := e2.(engine.Queuer)
+	if !ok {
+		t.Skip("The engine doesn't queue the waiters")
+	}
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{Priority: 1})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	acquired := make(chan error, 1)
+	go func() {
+		l, err := q.Acquire(ctx, key, engine.LockOptions{Priority: 2, Preempt: true})
+		if err == nil {
+			l.Unlock(context.Background())
+		}
+		acquired <- err
+	}()
+
+	select {
+	case <-l.Yield():
+	case <-time.After(waitTimeout):
+		t.Fatalf("The holder should be asked to yield, it wasn't")
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Errorf("Unlock shouldn't return an error: %v", err)
+	}
+	if err := <-acquired; err != nil {
+		t.Errorf("Acquire shouldn't return an error: %v", err)
+	}
+}
+
+func testQueueCancelled(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	q, ok := e2.(engine.Queuer)
+	if !ok {
+		t.Skip("The engine doesn't queue the waiters")
+	}
+	l, err := e1.Lock(context.Background(), key, engine.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TTL)
+	defer cancel()
+	_, err = q.Acquire(ctx, key, engine.LockOptions{})
+	checkError(t, e2, key, err, context.DeadlineExceeded)
+
+	// The cancelled waiter is not granted the lock
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Errorf("Unlock shouldn't return an error: %v", err)
+	}
+	time.Sleep(TTL)
+	checkLocked(t, e1, key, false)
+}
+
+func testClose(t *testing.T, newEngine Factory, key string) {
+	e1, e2 := newEngine(), newEngine()
+	var leases []engine.Lease
+	for i := 0; i < 3; i++ {
+		l, err := e1.Lock(context.Background(), fmt.Sprintf("%s-%d", key, i), engine.LockOptions{})
+		if err != nil {
+			t.Fatalf("Lock shouldn't return an error: %v", err)
+		}
+		leases = append(leases, l)
+	}
+
+	if err := e1.Close(context.Background()); err != nil {
+		t.Fatalf("Close shouldn't return an error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		checkLocked(t, e2, fmt.Sprintf("%s-%d", key, i), false)
+	}
+	// The engine can be closed only once so the lost signal is checked here
+	t.Run("Close signals Lost on held leases", func(t *testing.T) {
+		for _, l := range leases {
+			select {
+			case <-l.Lost():
+			case <-time.After(waitTimeout):
+				t.Errorf("The lost signal should be received when the engine is closed, it didn't")
+			}
+		}
+	})
+
+	_, err := e1.Lock(context.Background(), key, engine.LockOptions{})
+	checkError(t, e1, key, err, engine.ErrClosed)
+	if err := e1.Close(context.Background()); err != nil {
+		t.Errorf("Closing twice shouldn't return an error: %v", err)
+	}
+}
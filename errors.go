@@ -0,0 +1,19 @@
+package warlock
+
+import (
+	"github.com/slok/warlock/engine"
+)
+
+// Errors returned by the locks, they are the engine errors so they can be
+// checked with errors.Is
+var (
+	ErrLocked             = engine.ErrLocked
+	ErrNotLocked          = engine.ErrNotLocked
+	ErrNotOwner           = engine.ErrNotOwner
+	ErrLeaseLost          = engine.ErrLeaseLost
+	ErrCorruptLock        = engine.ErrCorruptLock
+	ErrBackendUnavailable = engine.ErrBackendUnavailable
+	ErrClosed             = engine.ErrClosed
+	ErrDeadlock           = engine.ErrDeadlock
+	ErrPreempted          = engine.ErrPreempted
+)
@@ -1,59 +1,618 @@
 package warlock
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/slok/warlock/audit"
+	"github.com/slok/warlock/clock"
 	"github.com/slok/warlock/engine"
+	"github.com/slok/warlock/log"
+	"github.com/slok/warlock/metrics"
+	"github.com/slok/warlock/tracing"
 )
 
-// Warlock reprensents the lock object that holds the lock
+// Outcomes of the lock operations set on the traces
+const (
+	acquiredOutcome  = "acquired"
+	lockedOutcome    = "locked"
+	releasedOutcome  = "released"
+	extendedOutcome  = "extended"
+	notLockedOutcome = "not_locked"
+	cancelledOutcome = "cancelled"
+	errorOutcome     = "error"
+)
+
+// Warlock reprensents the lock object that holds the lock, it's a single key
+// convenience wrapper over the engine
 type Warlock struct {
 	// The lock key that will identify the lock
-	//Key string
+	Key string
+
+	// Namespace prefixes the key on the engine so different applications
+	// don't collide, optional
+	Namespace string
 
 	// Engine will reprenset the locks engine
 	Engine engine.Engine
+
+	// Options are the options used to lock the key
+	Options engine.LockOptions
+
+	// Acquire are the options used to acquire the lock when it's locked,
+	// it fails fast if not set
+	Acquire AcquireOptions
+
+	// Metrics is the recorder of the lock metrics, optional
+	Metrics metrics.Recorder
+
+	// Tracer is the tracer used to trace the lock operations, optional
+	Tracer tracing.Tracer
+
+	// Logger is the logger of the lock, optional
+	Logger log.Logger
+
+	// Clock is the source of time of the lock, the system clock if not set
+	Clock clock.Clock
+
+	// Audit is the sink of the lock attempts events, the rest of the
+	// transitions are emitted by the engine. Optional
+	Audit audit.Sink
+
+	mu       sync.Mutex
+	lease    engine.Lease
+	lockedAt time.Time
+	closed   bool
+	done     chan struct{}
 }
 
-// Lock locks the lock
-func (w *Warlock) Lock() error {
-	// Check if is already locked
-	l, err := w.Engine.Locked()
-	if err != nil {
+// Lock locks the lock, if it's locked it's retried with the acquisition
+// strategy
+func (w *Warlock) Lock(ctx context.Context) error {
+	return w.acquire(ctx, "warlock.Lock", w.Acquire)
+}
+
+// TryLock tries to lock the lock once whatever the acquisition strategy, the
+// lock being locked is not an error: the result has the holder of the lock as
+// reported by the engine
+func (w *Warlock) TryLock(ctx context.Context) (LockResult, error) {
+	err := w.acquire(ctx, "warlock.TryLock", AcquireOptions{})
+	if err == nil {
+		res := LockResult{Acquired: true}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.lease != nil {
+			res.Token = w.lease.Token()
+		}
+		return res, nil
+	}
+	if !errors.Is(err, ErrLocked) {
+		return LockResult{}, err
+	}
+
+	var res LockResult
+	var aerr *AcquireError
+	if errors.As(err, &aerr) {
+		res.Holder = aerr.Holder
+	}
+	if d, ok := w.Engine.(engine.Describer); ok {
+		info, err := d.Describe(ctx, w.key())
+		if err != nil {
+			return res, err
+		}
+		if info != nil {
+			res.Holder, res.TTL, res.Token = info.Holder, info.TTL, info.Token
+		}
+	}
+	return res, nil
+}
+
+// acquire locks the lock with the acquisition options
+func (w *Warlock) acquire(ctx context.Context, name string, opts AcquireOptions) error {
+	ctx, span := w.startSpan(ctx, name)
+	defer span.End()
+
+	if w.isClosed() {
+		w.recorder().IncLockAttempt(w.Engine.Name(), w.key())
+		w.recorder().IncLockFailed(w.Engine.Name(), w.key())
+		err := engine.NewError(w.Engine.Name(), w.key(), ErrClosed)
+		failSpan(span, err)
 		return err
 	}
-	if l {
-		return fmt.Errorf("already locked")
+	if w.held() {
+		// The engines with shared modes would lock it again
+		w.recorder().IncLockAttempt(w.Engine.Name(), w.key())
+		w.recorder().IncLockFailed(w.Engine.Name(), w.key())
+		span.SetAttributes(tracing.String(tracing.OutcomeAttr, lockedOutcome))
+		err := engine.NewError(w.Engine.Name(), w.key(), fmt.Errorf("%w: already held by the lock", ErrLocked))
+		return w.acquireError(1, err)
+	}
+	if opts.Deadlocks != nil && w.Options.Owner == "" {
+		// The anonymous waiters can't be told apart on the graph
+		err := fmt.Errorf("deadlock detection requires the lock owner")
+		failSpan(span, err)
+		return err
 	}
 
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	done := w.closing()
+
+	// Record the wait on the wait-for graph while retrying
+	var waiting engine.Lease
+	defer func() {
+		if waiting != nil {
+			waiting.Unlock(context.WithoutCancel(ctx))
+		}
+	}()
+
 	// Lock
-	if err = w.Engine.Lock(); err != nil {
-		return err
+	var lease engine.Lease
+	attempts := 0
+	for {
+		attempts++
+		w.recorder().IncLockAttempt(w.Engine.Name(), w.key())
+		var err error
+		lease, err = w.lock(ctx, opts, done)
+		w.emit(audit.Attempt, w.Options.Owner, 0, err)
+		if err == nil {
+			break
+		}
+		w.recorder().IncLockFailed(w.Engine.Name(), w.key())
+		span.SetAttributes(tracing.Int64(tracing.AttemptsAttr, int64(attempts)))
+		if !errors.Is(err, ErrLocked) {
+			failSpan(span, err)
+			return w.acquireError(attempts, err)
+		}
+
+		wait, ok := opts.retryWait(attempts)
+		if !ok {
+			span.SetAttributes(tracing.String(tracing.OutcomeAttr, lockedOutcome))
+			return w.acquireError(attempts, err)
+		}
+		if g := opts.Deadlocks; g != nil && waiting == nil {
+			if waiting, err = g.Wait(ctx, w.Options.Owner, w.key()); err != nil {
+				failSpan(span, err)
+				return w.acquireError(attempts, err)
+			}
+		}
+		if err := w.waitRetry(ctx, opts, done, wait); err != nil {
+			failSpan(span, err)
+			return w.acquireError(attempts, err)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		// Closed while locking
+		lease.Unlock(ctx)
+		w.recorder().IncLockFailed(w.Engine.Name(), w.key())
+		err := engine.NewError(w.Engine.Name(), w.key(), ErrClosed)
+		failSpan(span, err)
+		return w.acquireError(attempts, err)
 	}
+	if w.lease != nil {
+		// Locked twice concurrently
+		lease.Unlock(ctx)
+		w.recorder().IncLockFailed(w.Engine.Name(), w.key())
+		err := engine.NewError(w.Engine.Name(), w.key(), fmt.Errorf("%w: already held by the lock", ErrLocked))
+		failSpan(span, err)
+		return w.acquireError(attempts, err)
+	}
+	w.lease = lease
+	w.lockedAt = w.clock().Now()
+	w.recorder().IncLockAcquired(w.Engine.Name(), w.key())
+	w.recorder().AddHeld(w.Engine.Name(), w.key(), 1)
+	span.SetAttributes(
+		tracing.String(tracing.OutcomeAttr, acquiredOutcome),
+		tracing.Int64(tracing.AttemptsAttr, int64(attempts)),
+		tracing.Int64(tracing.TokenAttr, int64(lease.Token())),
+	)
+	w.logger().Debug("lock acquired")
 
 	return nil
 }
 
+// lock locks the key on the engine, queued on the engine with the queue
+// strategy
+func (w *Warlock) lock(ctx context.Context, opts AcquireOptions, done <-chan struct{}) (engine.Lease, error) {
+	if opts.Strategy != Queue {
+		return w.Engine.Lock(ctx, w.key(), w.Options)
+	}
+
+	q, ok := w.Engine.(engine.Queuer)
+	if !ok {
+		return nil, fmt.Errorf("%s engine can't queue the waiters", w.Engine.Name())
+	}
+	qctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-qctx.Done():
+		}
+	}()
+	lease, err := q.Acquire(qctx, w.key(), w.Options)
+	if err != nil && ctx.Err() == nil && w.isClosed() {
+		return nil, engine.NewError(w.Engine.Name(), w.key(), ErrClosed)
+	}
+	return lease, err
+}
+
+// waitRetry waits until the lock can be retried, the wait is the time to
+// wait or the engine release notification with the wait release strategy.
+// Meanwhile the deadlocks are checked if the wait-for graph is set
+func (w *Warlock) waitRetry(ctx context.Context, opts AcquireOptions, done <-chan struct{}, wait time.Duration) error {
+	var (
+		released <-chan struct{}
+		timer    <-chan time.Time
+	)
+	if opts.Strategy == WaitRelease {
+		wctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		released = w.Engine.Wait(wctx, w.key())
+	} else {
+		t := w.clock().NewTimer(wait)
+		defer t.Stop()
+		timer = t.C()
+	}
+
+	g := opts.Deadlocks
+	for {
+		var check clock.Timer
+		if g != nil {
+			if err := w.checkDeadlock(ctx, g); err != nil {
+				return err
+			}
+			check = w.clock().NewTimer(g.NextCheck())
+		}
+
+		if finished, err := w.waitCheck(ctx, done, released, timer, check); finished {
+			return err
+		}
+	}
+}
+
+// waitCheck waits for the retry or the next deadlock check, returns true if
+// the wait finished
+func (w *Warlock) waitCheck(ctx context.Context, done, released <-chan struct{}, timer <-chan time.Time, check clock.Timer) (bool, error) {
+	var checkC <-chan time.Time
+	if check != nil {
+		defer check.Stop()
+		checkC = check.C()
+	}
+
+	select {
+	case <-released:
+		return true, nil
+	case <-timer:
+		return true, nil
+	case <-checkC:
+		return false, nil
+	case <-ctx.Done():
+		return true, ctx.Err()
+	case <-done:
+		return true, engine.NewError(w.Engine.Name(), w.key(), ErrClosed)
+	}
+}
+
+// checkDeadlock returns ErrDeadlock if the lock waits on a deadlock and it's
+// the victim that must abort, the check failures don't abort the wait
+func (w *Warlock) checkDeadlock(ctx context.Context, g *engine.WaitGraph) error {
+	victim, err := g.Victim(ctx, w.Options.Owner, w.key())
+	if err != nil {
+		w.logger().Warn("deadlock check failed", log.F(log.ErrorField, err))
+		return nil
+	}
+	if !victim {
+		return nil
+	}
+	w.logger().Warn("deadlock detected, aborting the wait")
+	return engine.NewError(w.Engine.Name(), w.key(), ErrDeadlock)
+}
+
 // Unlock unlocks the lock
-func (w *Warlock) Unlock() error {
+func (w *Warlock) Unlock(ctx context.Context) error {
+	ctx, span := w.startSpan(ctx, "warlock.Unlock")
+	defer span.End()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.unlock(ctx, span)
+}
+
+// unlock unlocks the lock, it must be called with the lock locked
+func (w *Warlock) unlock(ctx context.Context, span tracing.Span) error {
 	// If not locked then can't be unlocked
-	l, err := w.Engine.Locked()
-	if err != nil {
-		return err
+	if w.lease == nil {
+		span.SetAttributes(tracing.String(tracing.OutcomeAttr, notLockedOutcome))
+		return engine.NewError(w.Engine.Name(), w.key(), ErrNotLocked)
 	}
-	if !l {
-		return fmt.Errorf("not locked")
+
+	// Unlock, if the lock is not held anymore the lease is useless
+	if err := w.lease.Unlock(ctx); err != nil {
+		failSpan(span, err)
+		if errors.Is(err, ErrNotLocked) || errors.Is(err, ErrNotOwner) || errors.Is(err, ErrLeaseLost) {
+			w.release()
+		}
+		return err
 	}
+	w.release()
+	span.SetAttributes(tracing.String(tracing.OutcomeAttr, releasedOutcome))
+	w.logger().Debug("lock released")
 
-	// Unlock
-	if err = w.Engine.Unlock(); err != nil {
+	return nil
+}
+
+// Do runs f holding the lock, the context passed to f is cancelled if the lock
+// is lost, closed or preempted meanwhile (context.Cause tells why). The lock is released
+// when f returns or panics, the returned error joins the errors of f and the
+// unlock
+func (w *Warlock) Do(ctx context.Context, f func(ctx context.Context) error) (err error) {
+	if err := w.Lock(ctx); err != nil {
 		return err
 	}
+	w.mu.Lock()
+	lease := w.lease
+	w.mu.Unlock()
+
+	fctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-lease.Lost():
+			w.logger().Warn("lock lost while running")
+			cancel(engine.NewError(w.Engine.Name(), w.key(), ErrLeaseLost))
+		case <-lease.Yield():
+			w.logger().Info("lock preempted while running")
+			cancel(engine.NewError(w.Engine.Name(), w.key(), ErrPreempted))
+		case <-w.closing():
+			cancel(engine.NewError(w.Engine.Name(), w.key(), ErrClosed))
+		case <-fctx.Done():
+		}
+	}()
+
+	// Release even if f panics, the unlock can't be cancelled by the context
+	defer func() {
+		if uerr := w.Unlock(context.WithoutCancel(ctx)); uerr != nil && !errors.Is(uerr, ErrNotLocked) {
+			err = errors.Join(err, uerr)
+		}
+	}()
+
+	return f(fctx)
+}
+
+// Heartbeat tells the lock the holder is alive, with the Heartbeat option
+// set the lock is only kept while the holder heartbeats within the window
+func (w *Warlock) Heartbeat() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lease != nil {
+		w.lease.Heartbeat()
+	}
+}
+
+// Yield returns a channel that is closed when a higher priority waiter asks
+// the held lock to be released, nil if the lock is not held
+func (w *Warlock) Yield() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lease == nil {
+		return nil
+	}
+	return w.lease.Yield()
+}
+
+// Extend makes the held lock expire d from now
+func (w *Warlock) Extend(ctx context.Context, d time.Duration) error {
+	return w.update(ctx, "warlock.Extend", func(ctx context.Context, l engine.Lease) error {
+		return l.Extend(ctx, d)
+	})
+}
+
+// SetTTL changes the TTL of the held lock
+func (w *Warlock) SetTTL(ctx context.Context, ttl time.Duration) error {
+	return w.update(ctx, "warlock.SetTTL", func(ctx context.Context, l engine.Lease) error {
+		return l.SetTTL(ctx, ttl)
+	})
+}
 
+// update updates the held lease, if the lock is not held anymore the lease
+// is useless
+func (w *Warlock) update(ctx context.Context, name string, f func(context.Context, engine.Lease) error) error {
+	ctx, span := w.startSpan(ctx, name)
+	defer span.End()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lease == nil {
+		span.SetAttributes(tracing.String(tracing.OutcomeAttr, notLockedOutcome))
+		return engine.NewError(w.Engine.Name(), w.key(), ErrNotLocked)
+	}
+
+	if err := f(ctx, w.lease); err != nil {
+		failSpan(span, err)
+		if errors.Is(err, ErrNotLocked) || errors.Is(err, ErrNotOwner) || errors.Is(err, ErrLeaseLost) {
+			w.release()
+		}
+		return err
+	}
+	span.SetAttributes(tracing.String(tracing.OutcomeAttr, extendedOutcome))
 	return nil
 }
 
-// Wait returns a channel so it waits until the lock is released
-func (w *Warlock) Wait() <-chan struct{} {
-	return w.Engine.Wait()
+// Wait returns a channel so it waits until the lock is released, the span
+// of the wait will finish when the lock is released or the context cancelled
+func (w *Warlock) Wait(ctx context.Context) <-chan struct{} {
+	ctx, span := w.startSpan(ctx, "warlock.Wait")
+	start := w.clock().Now()
+	done := w.closing()
+	c := make(chan struct{})
+	// The engine wait is stopped when the lock is closed too
+	wctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer span.End()
+		defer cancel()
+		select {
+		case <-w.Engine.Wait(wctx, w.key()):
+			waited := w.clock().Since(start)
+			w.recorder().ObserveWaitDuration(w.Engine.Name(), w.key(), waited)
+			span.SetAttributes(
+				tracing.String(tracing.OutcomeAttr, releasedOutcome),
+				tracing.Duration(tracing.WaitedAttr, waited),
+			)
+			close(c)
+		case <-ctx.Done():
+			span.SetAttributes(
+				tracing.String(tracing.OutcomeAttr, cancelledOutcome),
+				tracing.Duration(tracing.WaitedAttr, w.clock().Since(start)),
+			)
+		case <-done:
+			span.SetAttributes(
+				tracing.String(tracing.OutcomeAttr, cancelledOutcome),
+				tracing.Duration(tracing.WaitedAttr, w.clock().Since(start)),
+			)
+		}
+	}()
+	return c
+}
+
+// Close releases the lock if held and stops the waits, the lock can't be
+// locked anymore. The engine is not closed as it can be shared with other
+// locks
+func (w *Warlock) Close(ctx context.Context) error {
+	ctx, span := w.startSpan(ctx, "warlock.Close")
+	defer span.End()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.done != nil {
+		close(w.done)
+	}
+
+	if w.lease == nil {
+		return nil
+	}
+	return w.unlock(ctx, span)
+}
+
+// key returns the key on the engine, with the namespace if set
+func (w *Warlock) key() string {
+	if w.Namespace == "" {
+		return w.Key
+	}
+	return w.Namespace + "/" + w.Key
+}
+
+// isClosed returns true if the lock is closed
+func (w *Warlock) isClosed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closed
+}
+
+// held returns true if the lock holds a lease
+func (w *Warlock) held() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lease != nil
+}
+
+// closing returns a channel closed when the lock is closed
+func (w *Warlock) closing() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done == nil {
+		w.done = make(chan struct{})
+		if w.closed {
+			close(w.done)
+		}
+	}
+	return w.done
+}
+
+// release forgets the held lease
+func (w *Warlock) release() {
+	w.recorder().ObserveHoldDuration(w.Engine.Name(), w.key(), w.clock().Since(w.lockedAt))
+	w.recorder().AddHeld(w.Engine.Name(), w.key(), -1)
+	w.lease = nil
+	w.lockedAt = time.Time{}
+}
+
+// clock returns the clock, the system one if not set
+func (w *Warlock) clock() clock.Clock {
+	if w.Clock == nil {
+		return clock.System
+	}
+	return w.Clock
+}
+
+// emit emits the audit event of the lock transition
+func (w *Warlock) emit(t audit.EventType, owner string, token uint64, err error) {
+	if w.Audit == nil {
+		return
+	}
+	e := audit.Event{
+		Type:   t,
+		Time:   w.clock().Now(),
+		Engine: w.Engine.Name(),
+		Key:    w.key(),
+		Owner:  owner,
+		Token:  token,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	w.Audit.Emit(e)
+}
+
+// recorder returns the metrics recorder, the dummy one if not set
+func (w *Warlock) recorder() metrics.Recorder {
+	if w.Metrics == nil {
+		return metrics.Dummy
+	}
+	return w.Metrics
+}
+
+// logger returns the logger with the lock fields set, the dummy one if not set
+func (w *Warlock) logger() log.Logger {
+	if w.Logger == nil {
+		return log.Dummy
+	}
+	l := w.Logger.With(log.F(log.EngineField, w.Engine.Name()), log.F(log.KeyField, w.key()))
+	if w.Options.Owner != "" {
+		l = l.With(log.F(log.OwnerField, w.Options.Owner))
+	}
+	return l
+}
+
+// startSpan starts a span with the tracer (the dummy one if not set) already
+// described with the lock attributes
+func (w *Warlock) startSpan(ctx context.Context, name string) (context.Context, tracing.Span) {
+	t := w.Tracer
+	if t == nil {
+		t = tracing.Dummy
+	}
+	return t.Start(ctx, name,
+		tracing.String(tracing.KeyAttr, w.key()),
+		tracing.String(tracing.EngineAttr, w.Engine.Name()),
+	)
+}
+
+// failSpan marks the span as failed
+func failSpan(span tracing.Span, err error) {
+	span.SetAttributes(tracing.String(tracing.OutcomeAttr, errorOutcome))
+	span.RecordError(err)
 }
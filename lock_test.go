@@ -1,9 +1,18 @@
 package warlock
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/slok/warlock/audit"
+	"github.com/slok/warlock/clock"
+	"github.com/slok/warlock/engine"
+	"github.com/slok/warlock/metrics"
+	"github.com/slok/warlock/tracing"
 )
 
 const (
@@ -12,135 +21,694 @@ const (
 
 // TestEngine is an engine only for testing purposes
 type TestEngine struct {
-	Key   string
 	locks map[string]interface{}
 	waitT time.Duration
 }
 
-func newTestEngine(key string) *TestEngine {
+func newTestEngine() *TestEngine {
 	return &TestEngine{
-		Key:   key,
 		locks: make(map[string]interface{}),
 	}
 }
 
-func (t *TestEngine) Lock() error {
-	if _, ok := t.locks[t.Key]; ok {
-		return fmt.Errorf("already locked")
-	}
-
-	t.locks[t.Key] = nil
+type testLease struct {
+	t    *TestEngine
+	key  string
+	lost chan struct{}
+}
 
-	return nil
+func (t *TestEngine) Name() string {
+	return "test"
 }
 
-func (t *TestEngine) Unlock() error {
-	if _, ok := t.locks[t.Key]; !ok {
-		return fmt.Errorf("not locked")
+func (t *TestEngine) Lock(ctx context.Context, key string, opts engine.LockOptions) (engine.Lease, error) {
+	if _, ok := t.locks[key]; ok {
+		return nil, engine.NewError(t.Name(), key, engine.ErrLocked)
 	}
 
-	delete(t.locks, t.Key)
+	t.locks[key] = nil
 
-	return nil
+	return &testLease{t: t, key: key, lost: make(chan struct{})}, nil
 }
 
-func (t *TestEngine) Locked() (bool, error) {
-	if _, ok := t.locks[t.Key]; ok {
+func (t *TestEngine) Locked(ctx context.Context, key string) (bool, error) {
+	if _, ok := t.locks[key]; ok {
 		return true, nil
 	}
 
 	return false, nil
 }
 
-func (t *TestEngine) Wait() <-chan struct{} {
+func (t *TestEngine) Wait(ctx context.Context, key string) <-chan struct{} {
 	c := make(chan struct{})
 	go func() {
-		time.Sleep(t.waitT)
-		close(c)
+		select {
+		case <-time.After(t.waitT):
+			close(c)
+		case <-ctx.Done():
+		}
 	}()
 	return c
 }
 
+func (l *testLease) Extend(ctx context.Context, d time.Duration) error {
+	if _, ok := l.t.locks[l.key]; !ok {
+		return engine.NewError(l.t.Name(), l.key, engine.ErrNotLocked)
+	}
+	return nil
+}
+
+func (l *testLease) SetTTL(ctx context.Context, ttl time.Duration) error {
+	return l.Extend(ctx, ttl)
+}
+
+func (l *testLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *testLease) Heartbeat() {}
+
+func (l *testLease) Yield() <-chan struct{} {
+	return nil
+}
+
+func (l *testLease) Token() uint64 {
+	return 0
+}
+
+func (t *TestEngine) Close(ctx context.Context) error {
+	return nil
+}
+
+func (l *testLease) Key() string {
+	return l.key
+}
+
+func (l *testLease) Unlock(ctx context.Context) error {
+	if _, ok := l.t.locks[l.key]; !ok {
+		return engine.NewError(l.t.Name(), l.key, engine.ErrNotLocked)
+	}
+
+	delete(l.t.locks, l.key)
+
+	return nil
+}
+
 // Tests
 
 func TestLock(t *testing.T) {
 	l := Warlock{
-		Engine: newTestEngine(key),
+		Key:    key,
+		Engine: newTestEngine(),
 	}
-	if err := l.Lock(); err != nil {
+	if err := l.Lock(context.Background()); err != nil {
 		t.Errorf("Lock shouldn't return an error: %v", err)
 	}
 }
 
 func TestLockBeingLocked(t *testing.T) {
-	e := newTestEngine(key)
+	e := newTestEngine()
 	l1 := Warlock{
+		Key:    key,
 		Engine: e,
 	}
 
-	l1.Lock()
+	l1.Lock(context.Background())
 	l2 := Warlock{
+		Key:    key,
 		Engine: e,
 	}
-	if err := l2.Lock(); err == nil {
-		t.Errorf("Lock should return an error, it didn't")
+	if err := l2.Lock(context.Background()); !errors.Is(err, ErrLocked) {
+		t.Errorf("Lock should return a locked error, it didn't: %v", err)
 	}
 }
 
 func TestUnlock(t *testing.T) {
 	l := Warlock{
-		Engine: newTestEngine(key),
+		Key:    key,
+		Engine: newTestEngine(),
 	}
-	l.Lock()
-	if err := l.Unlock(); err != nil {
+	l.Lock(context.Background())
+	if err := l.Unlock(context.Background()); err != nil {
 		t.Errorf("Unlock shouldn't return an error: %v", err)
 	}
 }
 
 func TestUnlockWithoutLock(t *testing.T) {
 	l := Warlock{
-		Engine: newTestEngine(key),
+		Key:    key,
+		Engine: newTestEngine(),
 	}
-	if err := l.Unlock(); err == nil {
-		t.Errorf("Unlock should return an error, it didn't")
+	if err := l.Unlock(context.Background()); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("Unlock should return a not locked error, it didn't: %v", err)
 	}
 }
 
 func TestLockWait(t *testing.T) {
-	e := newTestEngine(key)
+	e := newTestEngine()
 	e.waitT = 10 * time.Millisecond
 	l := Warlock{
+		Key:    key,
 		Engine: e,
 	}
-	if err := l.Lock(); err != nil {
+	if err := l.Lock(context.Background()); err != nil {
 		t.Fatalf("Lock shouldn't return an error, it did: %v", err)
 	}
 
 	l2 := Warlock{
+		Key:    key,
 		Engine: e,
 	}
 	time.Sleep(1 * time.Millisecond)
-	if err := l2.Lock(); err == nil {
+	if err := l2.Lock(context.Background()); err == nil {
 		t.Fatalf("Lock should return an error, it didn't")
 	}
 
-	var unlocked bool
-	go func() {
-		// Wait until it unlocks
-		<-l2.Wait()
-		unlocked = true
-	}()
+	// Wait until it unlocks
+	w := l2.Wait(context.Background())
 
 	// Check we didn't received while blocked by f (the one with the lock)
-	if unlocked {
+	select {
+	case <-w:
 		t.Errorf("The unlock signal shouldn't be received, it did")
+	default:
 	}
 
-	l.Unlock()
-	time.Sleep(11 * time.Millisecond)
+	l.Unlock(context.Background())
 
-	if !unlocked {
+	select {
+	case <-w:
+	case <-time.After(100 * time.Millisecond):
 		t.Errorf("The unlock signal should be received, it didn't")
 	}
+}
+
+func TestLockWaitCancel(t *testing.T) {
+	e := newTestEngine()
+	e.waitT = 10 * time.Millisecond
+	l := Warlock{
+		Key:    key,
+		Engine: e,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := l.Wait(ctx)
+	cancel()
+
+	select {
+	case <-w:
+		t.Errorf("The unlock signal shouldn't be received after cancelling the wait, it did")
+	case <-time.After(5 * e.waitT):
+	}
+}
+
+func TestLockNamespace(t *testing.T) {
+	e := newTestEngine()
+	l := Warlock{
+		Key:       key,
+		Namespace: "app",
+		Engine:    e,
+	}
+	if err := l.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error, it did: %v", err)
+	}
+	if locked, _ := e.Locked(context.Background(), "app/"+key); !locked {
+		t.Errorf("The key should be locked with the namespace, it wasn't")
+	}
+	if locked, _ := e.Locked(context.Background(), key); locked {
+		t.Errorf("The key shouldn't be locked without the namespace, it was")
+	}
+}
+
+func TestDo(t *testing.T) {
+	e := newTestEngine()
+	l := Warlock{
+		Key:    key,
+		Engine: e,
+	}
+	var ran bool
+	err := l.Do(context.Background(), func(ctx context.Context) error {
+		ran = true
+		if locked, _ := e.Locked(ctx, key); !locked {
+			t.Errorf("The lock should be held while running, it wasn't")
+		}
+		return nil
+	})
+	if err != nil || !ran {
+		t.Errorf("Do should run without errors; got %v (ran: %t)", err, ran)
+	}
+	if locked, _ := e.Locked(context.Background(), key); locked {
+		t.Errorf("The lock should be released after running, it wasn't")
+	}
+}
+
+func TestDoError(t *testing.T) {
+	e := newTestEngine()
+	l := Warlock{
+		Key:    key,
+		Engine: e,
+	}
+	fErr := errors.New("wanted error")
+	if err := l.Do(context.Background(), func(ctx context.Context) error { return fErr }); !errors.Is(err, fErr) {
+		t.Errorf("Do should return the error of the function; got %v", err)
+	}
+	if locked, _ := e.Locked(context.Background(), key); locked {
+		t.Errorf("The lock should be released after failing, it wasn't")
+	}
+
+	// Locked, the function is not run
+	e.locks[key] = nil
+	err := l.Do(context.Background(), func(ctx context.Context) error {
+		t.Errorf("The function shouldn't run without the lock, it did")
+		return nil
+	})
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("Do should return a locked error; got %v", err)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	e := newTestEngine()
+	l := Warlock{
+		Key:    key,
+		Engine: e,
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Do shouldn't recover the panic, it did")
+			}
+		}()
+		l.Do(context.Background(), func(ctx context.Context) error { panic("wanted panic") })
+	}()
+	if locked, _ := e.Locked(context.Background(), key); locked {
+		t.Errorf("The lock should be released after panicking, it wasn't")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	l := Warlock{
+		Key:     key,
+		Engine:  &engine.Memory{},
+		Options: engine.LockOptions{Heartbeat: 20 * time.Millisecond},
+	}
+	// Not locked, nothing to do
+	l.Heartbeat()
+
+	err := l.Do(context.Background(), func(ctx context.Context) error {
+		for i := 0; i < 10; i++ {
+			time.Sleep(5 * time.Millisecond)
+			l.Heartbeat()
+		}
+		if ctx.Err() != nil {
+			t.Errorf("The lock shouldn't be lost while heartbeating, it was")
+		}
+
+		// Wedged
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Errorf("The lock should be lost when not heartbeating, it wasn't")
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Do should return a lease lost error; got %v", err)
+	}
+}
+
+func TestDoLost(t *testing.T) {
+	l := Warlock{
+		Key:     key,
+		Engine:  &engine.Memory{},
+		Options: engine.LockOptions{TTL: 10 * time.Millisecond, Expire: true},
+	}
+	err := l.Do(context.Background(), func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Errorf("The context should be cancelled when the lock is lost, it wasn't")
+		}
+		if cause := context.Cause(ctx); !errors.Is(cause, ErrLeaseLost) {
+			t.Errorf("The context should be cancelled by a lease lost error; got %v", cause)
+		}
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Do should return the cancel and the lease lost errors; got %v", err)
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	e := &engine.Memory{}
+	l1 := Warlock{Key: key, Engine: e, Options: engine.LockOptions{Owner: "owner1", TTL: time.Minute, Expire: true}}
+	l2 := Warlock{Key: key, Engine: e, Acquire: AcquireOptions{Strategy: WaitRelease}}
+	if err := l1.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	// The acquisition strategy is ignored
+	res, err := l2.TryLock(context.Background())
+	if err != nil {
+		t.Fatalf("TryLock shouldn't return an error when locked: %v", err)
+	}
+	if res.Acquired || res.Holder != "owner1" || res.Token != 1 {
+		t.Errorf("TryLock should tell the lock is held by owner1 with token 1; got %+v", res)
+	}
+	if res.TTL <= 0 || res.TTL > time.Minute {
+		t.Errorf("TryLock should tell the TTL left of the holder; got %s", res.TTL)
+	}
+
+	if err := l1.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	res, err = l2.TryLock(context.Background())
+	if err != nil {
+		t.Fatalf("TryLock shouldn't return an error: %v", err)
+	}
+	if !res.Acquired || res.Holder != "" || res.Token != 2 {
+		t.Errorf("TryLock should acquire the lock with token 2; got %+v", res)
+	}
+}
 
+func TestLockTwice(t *testing.T) {
+	// The shared locks of the intention engine can be locked again
+	e := &engine.Intention{Engine: &engine.Memory{}}
+	l := Warlock{Key: "a/b", Engine: e, Options: engine.LockOptions{Mode: engine.Shared}}
+	if err := l.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l.Lock(context.Background()); !errors.Is(err, ErrLocked) {
+		t.Errorf("Lock should return a locked error when already held, it didn't: %v", err)
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	if locked, _ := e.Locked(context.Background(), "a/b"); locked {
+		t.Errorf("Key shouldn't be locked after unlocking, it was")
+	}
+}
+
+func TestLockAudit(t *testing.T) {
+	h := &audit.Hub{}
+	events, unsubscribe := h.Subscribe(10)
+	defer unsubscribe()
+	e := &engine.Memory{Audit: h}
+	l1 := Warlock{Key: key, Engine: e, Options: engine.LockOptions{Owner: "owner1"}, Audit: h}
+	l2 := Warlock{Key: key, Engine: e, Audit: h}
+
+	if err := l1.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l2.Lock(context.Background()); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock should return a locked error, it didn't: %v", err)
+	}
+	if err := l1.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	// The owner chosen by the engine is audited
+	if err := l2.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+
+	tests := []struct {
+		typ   audit.EventType
+		owner string
+		token uint64
+		err   bool
+	}{
+		{typ: audit.Acquired, owner: "owner1", token: 1},
+		{typ: audit.Attempt, owner: "owner1"},
+		{typ: audit.Attempt, err: true},
+		{typ: audit.Released, owner: "owner1", token: 1},
+		{typ: audit.Acquired, token: 2},
+		{typ: audit.Attempt},
+	}
+	for _, test := range tests {
+		var ev audit.Event
+		select {
+		case ev = <-events:
+		default:
+			t.Fatalf("The %s event should be emitted, it wasn't", test.typ)
+		}
+		if ev.Type != test.typ || ev.Key != key || ev.Engine != e.Name() || ev.Token != test.token || (ev.Error != "") != test.err {
+			t.Errorf("Wrong %s event: %+v", test.typ, ev)
+		}
+		if test.owner != "" && ev.Owner != test.owner {
+			t.Errorf("The %s event should be of %s; got %s", test.typ, test.owner, ev.Owner)
+		}
+		if ev.Type == audit.Acquired && ev.Owner == "" {
+			t.Errorf("The acquired event should have the owner, it didn't")
+		}
+	}
+}
+
+func TestDoPreempted(t *testing.T) {
+	e := &engine.Memory{}
+	l := Warlock{Key: key, Engine: e}
+	err := l.Do(context.Background(), func(ctx context.Context) error {
+		go (&Warlock{
+			Key:     key,
+			Engine:  e,
+			Options: engine.LockOptions{Priority: 1, Preempt: true},
+			Acquire: AcquireOptions{Strategy: Queue, Timeout: time.Second},
+		}).Lock(context.Background())
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Errorf("The context should be cancelled when the lock is preempted, it wasn't")
+		}
+		if cause := context.Cause(ctx); !errors.Is(cause, ErrPreempted) {
+			t.Errorf("The context should be cancelled by a preempted error; got %v", cause)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Do shouldn't return an error: %v", err)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	e := newTestEngine()
+	l := Warlock{
+		Key:    key,
+		Engine: e,
+	}
+	if err := l.Extend(context.Background(), time.Minute); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("Extend should return a not locked error, it didn't: %v", err)
+	}
+
+	if err := l.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error, it did: %v", err)
+	}
+	if err := l.Extend(context.Background(), time.Minute); err != nil {
+		t.Errorf("Extend shouldn't return an error, it did: %v", err)
+	}
+	if err := l.SetTTL(context.Background(), time.Minute); err != nil {
+		t.Errorf("SetTTL shouldn't return an error, it did: %v", err)
+	}
+
+	// Lost by the engine
+	delete(e.locks, key)
+	if err := l.Extend(context.Background(), time.Minute); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("Extend should return a not locked error, it didn't: %v", err)
+	}
+	if err := l.Lock(context.Background()); err != nil {
+		t.Errorf("Lock shouldn't return an error after losing the lease, it did: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	e := newTestEngine()
+	e.waitT = 10 * time.Millisecond
+	l := Warlock{
+		Key:    key,
+		Engine: e,
+	}
+	if err := l.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error, it did: %v", err)
+	}
+	w := l.Wait(context.Background())
+
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatalf("Close shouldn't return an error, it did: %v", err)
+	}
+	if locked, _ := e.Locked(context.Background(), key); locked {
+		t.Errorf("Close should release the lock, it didn't")
+	}
+	select {
+	case <-w:
+		t.Errorf("The unlock signal shouldn't be received after closing, it did")
+	case <-time.After(5 * e.waitT):
+	}
+
+	if err := l.Lock(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Lock should return a closed error, it didn't: %v", err)
+	}
+	if err := l.Close(context.Background()); err != nil {
+		t.Errorf("Closing twice shouldn't return an error, it did: %v", err)
+	}
+}
+
+// waitEngine is a test engine that exposes the contexts of its waits
+type waitEngine struct {
+	*TestEngine
+	waits chan context.Context
+}
+
+func (w *waitEngine) Wait(ctx context.Context, key string) <-chan struct{} {
+	w.waits <- ctx
+	return w.TestEngine.Wait(ctx, key)
+}
+
+func TestCloseStopsWait(t *testing.T) {
+	e := &waitEngine{TestEngine: newTestEngine(), waits: make(chan context.Context, 1)}
+	e.waitT = time.Hour
+	l := Warlock{
+		Key:    key,
+		Engine: e,
+	}
+	l.Wait(context.Background())
+	wctx := <-e.waits
+
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatalf("Close shouldn't return an error, it did: %v", err)
+	}
+	select {
+	case <-wctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Close should stop the engine wait, it didn't")
+	}
+}
+
+func TestLockMetrics(t *testing.T) {
+	m := metrics.NewPrometheus()
+	e := newTestEngine()
+	l := Warlock{
+		Key:     key,
+		Engine:  e,
+		Metrics: m,
+	}
+	l2 := Warlock{
+		Key:     key,
+		Engine:  e,
+		Metrics: m,
+	}
+	if err := l.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l2.Lock(context.Background()); err == nil {
+		t.Fatalf("Lock should return an error, it didn't")
+	}
+
+	b := &bytes.Buffer{}
+	m.WriteTo(b)
+	expected := []string{
+		`warlock_lock_attempts_total{engine="test",key="test_key"} 2`,
+		`warlock_lock_acquired_total{engine="test",key="test_key"} 1`,
+		`warlock_lock_failures_total{engine="test",key="test_key"} 1`,
+		`warlock_locks_held{engine="test",key="test_key"} 1`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(b.String(), exp) {
+			t.Errorf("Metrics should contain %q, they didn't:\n%s", exp, b.String())
+		}
+	}
+
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	b.Reset()
+	m.WriteTo(b)
+	expected = []string{
+		`warlock_locks_held{engine="test",key="test_key"} 0`,
+		`warlock_lock_hold_duration_seconds_count{engine="test",key="test_key"} 1`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(b.String(), exp) {
+			t.Errorf("Metrics should contain %q, they didn't:\n%s", exp, b.String())
+		}
+	}
+}
+
+func TestLockTracing(t *testing.T) {
+	tr := &tracing.Memory{}
+	l := Warlock{
+		Key:    key,
+		Engine: newTestEngine(),
+		Tracer: tr,
+	}
+	l.Lock(context.Background())
+	l.Lock(context.Background())
+	l.Unlock(context.Background())
+
+	spans := tr.Spans()
+	expected := []struct {
+		name    string
+		outcome string
+	}{
+		{"warlock.Lock", "acquired"},
+		{"warlock.Lock", "locked"},
+		{"warlock.Unlock", "released"},
+	}
+	if len(spans) != len(expected) {
+		t.Fatalf("Wrong number of spans, expected %d; got %d", len(expected), len(spans))
+	}
+	for i, exp := range expected {
+		s := spans[i]
+		if s.Name != exp.name {
+			t.Errorf("Wrong span name, expected %s; got %s", exp.name, s.Name)
+		}
+		if s.Attributes[tracing.OutcomeAttr] != exp.outcome {
+			t.Errorf("Wrong span outcome, expected %s; got %v", exp.outcome, s.Attributes[tracing.OutcomeAttr])
+		}
+		if s.Attributes[tracing.KeyAttr] != key || s.Attributes[tracing.EngineAttr] != "test" {
+			t.Errorf("Span should be described with the key and engine, it wasn't: %v", s.Attributes)
+		}
+		if !s.Ended {
+			t.Errorf("Span should be ended, it wasn't")
+		}
+	}
+}
+
+func TestLockTracingPropagatesContext(t *testing.T) {
+	tr := &tracing.Memory{}
+	l := Warlock{
+		Key:    key,
+		Engine: newTestEngine(),
+		Tracer: tr,
+	}
+	ctx, parent := tr.Start(context.Background(), "parent")
+	l.Lock(ctx)
+	parent.End()
+
+	spans := tr.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Wrong number of spans, expected 2; got %d", len(spans))
+	}
+	if spans[1].Parent == nil || spans[1].Parent.Name != "parent" {
+		t.Errorf("Lock span should be a child of the caller span, it wasn't")
+	}
+}
+
+func TestLockHoldDuration(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	m := metrics.NewPrometheus()
+	l := Warlock{
+		Key:     key,
+		Engine:  newTestEngine(),
+		Metrics: m,
+		Clock:   clk,
+	}
+	l.Lock(context.Background())
+	clk.Add(90 * time.Second)
+	l.Unlock(context.Background())
+
+	b := &bytes.Buffer{}
+	m.WriteTo(b)
+	exp := `warlock_lock_hold_duration_seconds_sum{engine="test",key="test_key"} 90`
+	if !strings.Contains(b.String(), exp) {
+		t.Errorf("Metrics should contain %q, they didn't:\n%s", exp, b.String())
+	}
 }
@@ -1,14 +1,50 @@
 package log
 
-import (
-	"github.com/uber-go/zap"
+// Field names used on the log messages
+const (
+	KeyField    = "key"
+	EngineField = "engine"
+	ErrorField  = "error"
+	OwnerField  = "owner"
 )
 
-// Logger is the logger used in the application
-var Logger zap.Logger
+// Field is a structured key value pair set on the log messages
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a new field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger is the leveled and structured logger used in the application, it can
+// be injected on the locks and the engines to route the logs to any logger
+type Logger interface {
+	// Debug logs a message at debug level
+	Debug(msg string, fields ...Field)
+
+	// Info logs a message at info level
+	Info(msg string, fields ...Field)
 
-func init() {
-	Logger = zap.New(
-		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
-	)
+	// Warn logs a message at warning level
+	Warn(msg string, fields ...Field)
+
+	// Error logs a message at error level
+	Error(msg string, fields ...Field)
+
+	// With returns a child logger that will set the fields on every message
+	With(fields ...Field) Logger
 }
+
+// Dummy is a logger that doesn't log anything, used as the default one
+var Dummy Logger = &dummy{}
+
+type dummy struct{}
+
+func (d *dummy) Debug(msg string, fields ...Field) {}
+func (d *dummy) Info(msg string, fields ...Field)  {}
+func (d *dummy) Warn(msg string, fields ...Field)  {}
+func (d *dummy) Error(msg string, fields ...Field) {}
+func (d *dummy) With(fields ...Field) Logger       { return d }
@@ -0,0 +1,45 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/uber-go/zap"
+)
+
+func TestSlogLogger(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := NewSlog(slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	l.With(F(KeyField, "k"), F(EngineField, "file")).Warn("msg", F(ErrorField, fmt.Errorf("wrong")))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		t.Fatalf("Log entry should be JSON: %v", err)
+	}
+	expected := map[string]interface{}{
+		"level":     "WARN",
+		"msg":       "msg",
+		KeyField:    "k",
+		EngineField: "file",
+		ErrorField:  "wrong",
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("Wrong log field %s, expected %v; got %v", k, v, entry[k])
+		}
+	}
+}
+
+func TestZapLogger(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := NewZap(zap.New(zap.NewJSONEncoder(zap.NoTime()), zap.Output(zap.AddSync(b))))
+	l.With(F(KeyField, "k")).Info("msg", F(EngineField, "file"))
+
+	expected := `{"level":"info","msg":"msg","key":"k","engine":"file"}` + "\n"
+	if b.String() != expected {
+		t.Errorf("Wrong log entry, expected %s; got %s", expected, b.String())
+	}
+}
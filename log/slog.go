@@ -0,0 +1,57 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Slog is a logger that uses a standard library structured logger
+type Slog struct {
+	l *slog.Logger
+}
+
+// NewSlog returns a new logger using the slog logger
+func NewSlog(l *slog.Logger) *Slog {
+	return &Slog{l: l}
+}
+
+// Debug satisfies Logger interface
+func (s *Slog) Debug(msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.LevelDebug, msg, slogAttrs(fields)...)
+}
+
+// Info satisfies Logger interface
+func (s *Slog) Info(msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.LevelInfo, msg, slogAttrs(fields)...)
+}
+
+// Warn satisfies Logger interface
+func (s *Slog) Warn(msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.LevelWarn, msg, slogAttrs(fields)...)
+}
+
+// Error satisfies Logger interface
+func (s *Slog) Error(msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.LevelError, msg, slogAttrs(fields)...)
+}
+
+// With satisfies Logger interface
+func (s *Slog) With(fields ...Field) Logger {
+	args := make([]interface{}, len(fields))
+	for i, a := range slogAttrs(fields) {
+		args[i] = a
+	}
+	return &Slog{l: s.l.With(args...)}
+}
+
+func slogAttrs(fields []Field) []slog.Attr {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		if err, ok := f.Value.(error); ok {
+			attrs[i] = slog.String(f.Key, err.Error())
+			continue
+		}
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	return attrs
+}
@@ -0,0 +1,55 @@
+package log
+
+import (
+	"github.com/uber-go/zap"
+)
+
+// Zap is a logger that uses a zap logger
+type Zap struct {
+	l zap.Logger
+}
+
+// NewZap returns a new logger using the zap logger
+func NewZap(l zap.Logger) *Zap {
+	return &Zap{l: l}
+}
+
+// Debug satisfies Logger interface
+func (z *Zap) Debug(msg string, fields ...Field) {
+	z.l.Debug(msg, zapFields(fields)...)
+}
+
+// Info satisfies Logger interface
+func (z *Zap) Info(msg string, fields ...Field) {
+	z.l.Info(msg, zapFields(fields)...)
+}
+
+// Warn satisfies Logger interface
+func (z *Zap) Warn(msg string, fields ...Field) {
+	z.l.Warn(msg, zapFields(fields)...)
+}
+
+// Error satisfies Logger interface
+func (z *Zap) Error(msg string, fields ...Field) {
+	z.l.Error(msg, zapFields(fields)...)
+}
+
+// With satisfies Logger interface
+func (z *Zap) With(fields ...Field) Logger {
+	return &Zap{l: z.l.With(zapFields(fields)...)}
+}
+
+func zapFields(fields []Field) []zap.Field {
+	zfs := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		switch v := f.Value.(type) {
+		case string:
+			zfs[i] = zap.String(f.Key, v)
+		case error:
+			zfs[i] = zap.String(f.Key, v.Error())
+		default:
+			zfs[i] = zap.Object(f.Key, v)
+		}
+	}
+	return zfs
+}
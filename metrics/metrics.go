@@ -0,0 +1,45 @@
+package metrics
+
+import "time"
+
+// Recorder knows how to record the metrics of the locks, the metrics will be
+// identified by the engine and the key of the lock
+type Recorder interface {
+	// IncLockAttempt increments the number of lock attempts
+	IncLockAttempt(engine, key string)
+
+	// IncLockAcquired increments the number of acquired locks
+	IncLockAcquired(engine, key string)
+
+	// IncLockFailed increments the number of failed lock attempts
+	IncLockFailed(engine, key string)
+
+	// ObserveWaitDuration measures the time waited until a lock was released
+	ObserveWaitDuration(engine, key string, d time.Duration)
+
+	// ObserveHoldDuration measures the time a lock was held
+	ObserveHoldDuration(engine, key string, d time.Duration)
+
+	// IncRenewalFailure increments the number of failed lock renewals
+	IncRenewalFailure(engine, key string)
+
+	// IncLockLost increments the number of locks lost while being held
+	IncLockLost(engine, key string)
+
+	// AddHeld adds (or substracts if negative) the number of currently held locks
+	AddHeld(engine, key string, delta int)
+}
+
+// Dummy is a recorder that doesn't record anything, used as the default one
+var Dummy Recorder = &dummy{}
+
+type dummy struct{}
+
+func (d *dummy) IncLockAttempt(engine, key string)                       {}
+func (d *dummy) IncLockAcquired(engine, key string)                      {}
+func (d *dummy) IncLockFailed(engine, key string)                        {}
+func (d *dummy) ObserveWaitDuration(engine, key string, t time.Duration) {}
+func (d *dummy) ObserveHoldDuration(engine, key string, t time.Duration) {}
+func (d *dummy) IncRenewalFailure(engine, key string)                    {}
+func (d *dummy) IncLockLost(engine, key string)                          {}
+func (d *dummy) AddHeld(engine, key string, delta int)                   {}
@@ -0,0 +1,283 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultNamespace is the namespace used on the metric names if none set
+	DefaultNamespace = "warlock"
+
+	attemptsMetric        = "lock_attempts_total"
+	acquiredMetric        = "lock_acquired_total"
+	failuresMetric        = "lock_failures_total"
+	waitDurationMetric    = "lock_wait_duration_seconds"
+	holdDurationMetric    = "lock_hold_duration_seconds"
+	renewalFailuresMetric = "lock_renewal_failures_total"
+	lostMetric            = "lock_lost_total"
+	heldMetric            = "locks_held"
+)
+
+// DefaultBuckets are the histogram buckets (in seconds) used if none set
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// help has the description of every metric
+var help = map[string]string{
+	attemptsMetric:        "Number of lock acquisition attempts.",
+	acquiredMetric:        "Number of acquired locks.",
+	failuresMetric:        "Number of failed lock acquisition attempts.",
+	waitDurationMetric:    "Time waited until a lock was released.",
+	holdDurationMetric:    "Time a lock was held.",
+	renewalFailuresMetric: "Number of failed lock renewals.",
+	lostMetric:            "Number of locks lost while being held.",
+	heldMetric:            "Number of currently held locks.",
+}
+
+// labels identifies the series of a metric
+type labels struct {
+	engine string
+	key    string
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Prometheus is a metrics recorder that exposes the metrics using the
+// Prometheus text exposition format, it implements http.Handler so it can be
+// served directly as the metrics endpoint
+type Prometheus struct {
+	// Namespace is the prefix of the metric names
+	Namespace string
+
+	// Buckets are the histogram buckets in seconds, the histograms keep the
+	// buckets set when they are created
+	Buckets []float64
+
+	mu         sync.Mutex
+	counters   map[string]map[labels]float64
+	gauges     map[string]map[labels]float64
+	histograms map[string]map[labels]*histogram
+}
+
+// NewPrometheus returns a new Prometheus recorder using the default namespace
+// and buckets
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		Namespace: DefaultNamespace,
+		Buckets:   DefaultBuckets,
+	}
+}
+
+// IncLockAttempt satisfies Recorder interface
+func (p *Prometheus) IncLockAttempt(engine, key string) {
+	p.addCounter(attemptsMetric, engine, key)
+}
+
+// IncLockAcquired satisfies Recorder interface
+func (p *Prometheus) IncLockAcquired(engine, key string) {
+	p.addCounter(acquiredMetric, engine, key)
+}
+
+// IncLockFailed satisfies Recorder interface
+func (p *Prometheus) IncLockFailed(engine, key string) {
+	p.addCounter(failuresMetric, engine, key)
+}
+
+// ObserveWaitDuration satisfies Recorder interface
+func (p *Prometheus) ObserveWaitDuration(engine, key string, d time.Duration) {
+	p.observe(waitDurationMetric, engine, key, d)
+}
+
+// ObserveHoldDuration satisfies Recorder interface
+func (p *Prometheus) ObserveHoldDuration(engine, key string, d time.Duration) {
+	p.observe(holdDurationMetric, engine, key, d)
+}
+
+// IncRenewalFailure satisfies Recorder interface
+func (p *Prometheus) IncRenewalFailure(engine, key string) {
+	p.addCounter(renewalFailuresMetric, engine, key)
+}
+
+// IncLockLost satisfies Recorder interface
+func (p *Prometheus) IncLockLost(engine, key string) {
+	p.addCounter(lostMetric, engine, key)
+}
+
+// AddHeld satisfies Recorder interface
+func (p *Prometheus) AddHeld(engine, key string, delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.gauges == nil {
+		p.gauges = map[string]map[labels]float64{}
+	}
+	if p.gauges[heldMetric] == nil {
+		p.gauges[heldMetric] = map[labels]float64{}
+	}
+	p.gauges[heldMetric][labels{engine: engine, key: key}] += float64(delta)
+}
+
+func (p *Prometheus) addCounter(name, engine, key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.counters == nil {
+		p.counters = map[string]map[labels]float64{}
+	}
+	if p.counters[name] == nil {
+		p.counters[name] = map[labels]float64{}
+	}
+	p.counters[name][labels{engine: engine, key: key}]++
+}
+
+func (p *Prometheus) observe(name, engine, key string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.histograms == nil {
+		p.histograms = map[string]map[labels]*histogram{}
+	}
+	if p.histograms[name] == nil {
+		p.histograms[name] = map[labels]*histogram{}
+	}
+	l := labels{engine: engine, key: key}
+	h, ok := p.histograms[name][l]
+	if !ok {
+		h = &histogram{
+			buckets: append([]float64(nil), p.Buckets...),
+			counts:  make([]uint64, len(p.Buckets)),
+		}
+		p.histograms[name][l] = h
+	}
+
+	v := d.Seconds()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// ServeHTTP writes all the metrics in the Prometheus text exposition format
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.WriteTo(w)
+}
+
+// WriteTo writes all the metrics in the Prometheus text exposition format
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b bytes.Buffer
+	names := []string{}
+	for name := range p.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.writeHeader(&b, name, "counter")
+		series := p.counters[name]
+		ls := []labels{}
+		for l := range series {
+			ls = append(ls, l)
+		}
+		for _, l := range sortLabels(ls) {
+			fmt.Fprintf(&b, "%s%s %s\n", p.fullName(name), l, formatFloat(series[l]))
+		}
+	}
+
+	names = []string{}
+	for name := range p.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.writeHeader(&b, name, "gauge")
+		series := p.gauges[name]
+		ls := []labels{}
+		for l := range series {
+			ls = append(ls, l)
+		}
+		for _, l := range sortLabels(ls) {
+			fmt.Fprintf(&b, "%s%s %s\n", p.fullName(name), l, formatFloat(series[l]))
+		}
+	}
+
+	names = []string{}
+	for name := range p.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.writeHeader(&b, name, "histogram")
+		series := p.histograms[name]
+		ls := []labels{}
+		for l := range series {
+			ls = append(ls, l)
+		}
+		for _, l := range sortLabels(ls) {
+			h := series[l]
+			for i, bk := range h.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", p.fullName(name), l.withLe(formatFloat(bk)), h.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", p.fullName(name), l.withLe("+Inf"), h.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", p.fullName(name), l, formatFloat(h.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", p.fullName(name), l, h.count)
+		}
+	}
+
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+func (p *Prometheus) writeHeader(b *bytes.Buffer, name, typ string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", p.fullName(name), help[name])
+	fmt.Fprintf(b, "# TYPE %s %s\n", p.fullName(name), typ)
+}
+
+func (p *Prometheus) fullName(name string) string {
+	if p.Namespace == "" {
+		return name
+	}
+	return p.Namespace + "_" + name
+}
+
+func (l labels) String() string {
+	return fmt.Sprintf(`{engine="%s",key="%s"}`, escapeLabel(l.engine), escapeLabel(l.key))
+}
+
+func (l labels) withLe(le string) string {
+	return fmt.Sprintf(`{engine="%s",key="%s",le="%s"}`, escapeLabel(l.engine), escapeLabel(l.key), le)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortLabels(ls []labels) []labels {
+	sort.Slice(ls, func(i, j int) bool {
+		if ls[i].engine != ls[j].engine {
+			return ls[i].engine < ls[j].engine
+		}
+		return ls[i].key < ls[j].key
+	})
+	return ls
+}
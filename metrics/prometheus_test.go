@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusHistogram(t *testing.T) {
+	p := NewPrometheus()
+	p.Buckets = []float64{0.1, 1}
+	p.ObserveWaitDuration("file", "k", 50*time.Millisecond)
+	p.ObserveWaitDuration("file", "k", 500*time.Millisecond)
+	p.ObserveWaitDuration("file", "k", 2*time.Second)
+
+	b := &bytes.Buffer{}
+	p.WriteTo(b)
+	expected := `# HELP warlock_lock_wait_duration_seconds Time waited until a lock was released.
+# TYPE warlock_lock_wait_duration_seconds histogram
+warlock_lock_wait_duration_seconds_bucket{engine="file",key="k",le="0.1"} 1
+warlock_lock_wait_duration_seconds_bucket{engine="file",key="k",le="1"} 2
+warlock_lock_wait_duration_seconds_bucket{engine="file",key="k",le="+Inf"} 3
+warlock_lock_wait_duration_seconds_sum{engine="file",key="k"} 2.55
+warlock_lock_wait_duration_seconds_count{engine="file",key="k"} 3
+`
+	if b.String() != expected {
+		t.Errorf("Wrong metrics output, expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestPrometheusHistogramBucketsChanged(t *testing.T) {
+	p := NewPrometheus()
+	p.Buckets = []float64{0.1, 1}
+	p.ObserveWaitDuration("file", "k", 50*time.Millisecond)
+
+	// The existing histograms keep their buckets
+	p.Buckets = []float64{0.1, 1, 10}
+	p.ObserveWaitDuration("file", "k", 5*time.Second)
+	p.ObserveWaitDuration("file", "k2", 5*time.Second)
+
+	b := &bytes.Buffer{}
+	p.WriteTo(b)
+	for _, exp := range []string{
+		`warlock_lock_wait_duration_seconds_bucket{engine="file",key="k",le="1"} 1`,
+		`warlock_lock_wait_duration_seconds_bucket{engine="file",key="k",le="+Inf"} 2`,
+		`warlock_lock_wait_duration_seconds_bucket{engine="file",key="k2",le="10"} 1`,
+	} {
+		if !strings.Contains(b.String(), exp) {
+			t.Errorf("Metrics should contain %q; got:\n%s", exp, b.String())
+		}
+	}
+	if strings.Contains(b.String(), `key="k",le="10"`) {
+		t.Errorf("The histogram shouldn't have the buckets set after its creation; got:\n%s", b.String())
+	}
+}
+
+func TestPrometheusHandlerEscapesLabels(t *testing.T) {
+	p := NewPrometheus()
+	p.IncLockLost("file", `a"b\c`)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	exp := `warlock_lock_lost_total{engine="file",key="a\"b\\c"} 1`
+	if !strings.Contains(w.Body.String(), exp) {
+		t.Errorf("Metrics should contain %q, they didn't:\n%s", exp, w.Body.String())
+	}
+}
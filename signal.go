@@ -0,0 +1,50 @@
+package warlock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Closer is anything holding locks that can be released on shutdown, like
+// the locks and the engines
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// CloseOnSignal closes the closers when the process receives SIGINT or
+// SIGTERM so the locks are released on an orderly exit, every close is given
+// up to timeout. The returned channel receives the close errors (nil if all
+// of them were closed) and is closed, if the context is cancelled before a
+// signal it's closed without closing anything:
+//
+//	err := <-warlock.CloseOnSignal(ctx, 10*time.Second, l, e)
+func CloseOnSignal(ctx context.Context, timeout time.Duration, closers ...Closer) <-chan error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	res := make(chan error, 1)
+	go func() {
+		defer close(res)
+		defer signal.Stop(sigs)
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigs:
+		}
+
+		var errs []error
+		for _, c := range closers {
+			cctx, cancel := context.WithTimeout(context.Background(), timeout)
+			if err := c.Close(cctx); err != nil {
+				errs = append(errs, err)
+			}
+			cancel()
+		}
+		res <- errors.Join(errs...)
+	}()
+	return res
+}
@@ -0,0 +1,53 @@
+package warlock
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCloseOnSignal(t *testing.T) {
+	e := newTestEngine()
+	l := &Warlock{
+		Key:    key,
+		Engine: e,
+	}
+	if err := l.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error, it did: %v", err)
+	}
+
+	res := CloseOnSignal(context.Background(), time.Second, l)
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-res:
+		if err != nil {
+			t.Errorf("Close shouldn't return an error, it did: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The locks should be closed on the signal, they weren't")
+	}
+	if locked, _ := e.Locked(context.Background(), key); locked {
+		t.Errorf("The lock should be released on the signal, it wasn't")
+	}
+}
+
+func TestCloseOnSignalCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	res := CloseOnSignal(ctx, time.Second)
+	cancel()
+
+	select {
+	case _, ok := <-res:
+		if ok {
+			t.Errorf("Nothing should be closed when cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The result should be closed when cancelled, it wasn't")
+	}
+}
@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+type spanCtxKey struct{}
+
+// MemorySpan is a span recorded by the Memory tracer
+type MemorySpan struct {
+	Name       string
+	Parent     *MemorySpan
+	Attributes map[string]interface{}
+	Err        error
+	Ended      bool
+
+	mu *sync.Mutex
+}
+
+// SetAttributes satisfies Span interface
+func (s *MemorySpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attrs {
+		s.Attributes[a.Key] = a.Value
+	}
+}
+
+// RecordError satisfies Span interface
+func (s *MemorySpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Err = err
+}
+
+// End satisfies Span interface
+func (s *MemorySpan) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Ended = true
+}
+
+// Memory is a tracer that records the spans in memory, it's useful for tests
+// and as a reference of how a tracer should be implemented (for example
+// adapting an OpenTelemetry tracer)
+type Memory struct {
+	mu    sync.Mutex
+	spans []*MemorySpan
+}
+
+// Start satisfies Tracer interface
+func (m *Memory) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	s := &MemorySpan{
+		Name:       name,
+		Attributes: map[string]interface{}{},
+		mu:         &m.mu,
+	}
+	if p, ok := ctx.Value(spanCtxKey{}).(*MemorySpan); ok {
+		s.Parent = p
+	}
+	s.SetAttributes(attrs...)
+
+	m.mu.Lock()
+	m.spans = append(m.spans, s)
+	m.mu.Unlock()
+
+	return context.WithValue(ctx, spanCtxKey{}, s), s
+}
+
+// Spans returns a copy of the recorded spans
+func (m *Memory) Spans() []MemorySpan {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]MemorySpan, len(m.spans))
+	for i, s := range m.spans {
+		res[i] = *s
+		res[i].Attributes = map[string]interface{}{}
+		for k, v := range s.Attributes {
+			res[i].Attributes[k] = v
+		}
+	}
+	return res
+}
+
+// Reset removes all the recorded spans
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = nil
+}
@@ -0,0 +1,30 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/slok/warlock/tracing"
+)
+
+func TestMemory(t *testing.T) {
+	tr := &tracing.Memory{}
+	ctx, parent := tr.Start(context.Background(), "parent", tracing.String(tracing.KeyAttr, "key"))
+	_, child := tr.Start(ctx, "child")
+	child.SetAttributes(tracing.Int64(tracing.TokenAttr, 3))
+	child.RecordError(errors.New("wrong"))
+	child.End()
+
+	spans := tr.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Wrong number of spans, expected 2; got %d", len(spans))
+	}
+	p, c := spans[0], spans[1]
+	if p.Name != "parent" || p.Parent != nil || p.Attributes[tracing.KeyAttr] != "key" || p.Ended {
+		t.Errorf("Wrong parent span: %+v", p)
+	}
+	if c.Name != "child" || c.Parent != parent || c.Attributes[tracing.TokenAttr] != int64(3) || c.Err == nil || !c.Ended {
+		t.Errorf("Wrong child span: %+v", c)
+	}
+}
@@ -0,0 +1,71 @@
+// Package otel adapts OpenTelemetry tracers to the warlock tracing hook
+package otel
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/slok/warlock/tracing"
+)
+
+// Tracer is a tracer that starts the spans on an OpenTelemetry tracer
+type Tracer struct {
+	t trace.Tracer
+}
+
+// NewTracer returns a new tracer using the OpenTelemetry tracer
+func NewTracer(t trace.Tracer) *Tracer {
+	return &Tracer{t: t}
+}
+
+// Start satisfies tracing.Tracer interface
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	ctx, s := t.t.Start(ctx, name, trace.WithAttributes(otelAttrs(attrs)...))
+	return ctx, &span{s: s}
+}
+
+// span is a tracing.Span of an OpenTelemetry span
+type span struct {
+	s trace.Span
+}
+
+// SetAttributes satisfies tracing.Span interface
+func (s *span) SetAttributes(attrs ...tracing.Attribute) {
+	s.s.SetAttributes(otelAttrs(attrs)...)
+}
+
+// RecordError satisfies tracing.Span interface
+func (s *span) RecordError(err error) {
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+// End satisfies tracing.Span interface
+func (s *span) End() {
+	s.s.End()
+}
+
+func otelAttrs(attrs []tracing.Attribute) []attribute.KeyValue {
+	res := make([]attribute.KeyValue, len(attrs))
+	for i, a := range attrs {
+		switch v := a.Value.(type) {
+		case string:
+			res[i] = attribute.String(a.Key, v)
+		case int64:
+			res[i] = attribute.Int64(a.Key, v)
+		case int:
+			res[i] = attribute.Int(a.Key, v)
+		case bool:
+			res[i] = attribute.Bool(a.Key, v)
+		case float64:
+			res[i] = attribute.Float64(a.Key, v)
+		default:
+			res[i] = attribute.String(a.Key, fmt.Sprint(v))
+		}
+	}
+	return res
+}
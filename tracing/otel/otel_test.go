@@ -0,0 +1,177 @@
+package otel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/slok/warlock"
+	"github.com/slok/warlock/engine"
+	"github.com/slok/warlock/tracing"
+	"github.com/slok/warlock/tracing/otel"
+)
+
+func TestTracer(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	defer tp.Shutdown(context.Background())
+	tr := otel.NewTracer(tp.Tracer("test"))
+
+	e := &engine.Memory{}
+	l1 := warlock.Warlock{Key: "key", Engine: e, Tracer: tr}
+	l2 := warlock.Warlock{Key: "key", Engine: e, Tracer: tr}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	if err := l1.Lock(ctx); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l2.Lock(ctx); !errors.Is(err, warlock.ErrLocked) {
+		t.Fatalf("Lock should return a locked error, it didn't: %v", err)
+	}
+	if err := l1.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+	_, s := tr.Start(ctx, "failed")
+	s.RecordError(errors.New("wrong"))
+	s.End()
+	parent.End()
+
+	spans := exp.GetSpans()
+	tests := []struct {
+		name   string
+		attrs  map[attribute.Key]attribute.Value
+		status codes.Code
+	}{
+		{
+			name: "warlock.Lock",
+			attrs: map[attribute.Key]attribute.Value{
+				tracing.KeyAttr:      attribute.StringValue("key"),
+				tracing.EngineAttr:   attribute.StringValue(e.Name()),
+				tracing.OutcomeAttr:  attribute.StringValue("acquired"),
+				tracing.AttemptsAttr: attribute.Int64Value(1),
+				tracing.TokenAttr:    attribute.Int64Value(1),
+			},
+		},
+		{
+			name: "warlock.Lock",
+			attrs: map[attribute.Key]attribute.Value{
+				tracing.KeyAttr:     attribute.StringValue("key"),
+				tracing.OutcomeAttr: attribute.StringValue("locked"),
+			},
+		},
+		{
+			name: "warlock.Unlock",
+			attrs: map[attribute.Key]attribute.Value{
+				tracing.OutcomeAttr: attribute.StringValue("released"),
+			},
+		},
+		{name: "failed", status: codes.Error},
+		{name: "parent"},
+	}
+	if len(spans) != len(tests) {
+		t.Fatalf("Wrong number of spans, expected %d; got %d", len(tests), len(spans))
+	}
+	for i, test := range tests {
+		s := spans[i]
+		if s.Name != test.name {
+			t.Errorf("Wrong span name, expected %s; got %s", test.name, s.Name)
+		}
+		if test.name != "parent" && s.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Span %s should be a child of the span on the context, it wasn't", s.Name)
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, a := range s.Attributes {
+			attrs[a.Key] = a.Value
+		}
+		for k, v := range test.attrs {
+			if attrs[k] != v {
+				t.Errorf("Wrong %s span attribute %s, expected %v; got %v", s.Name, k, v.Emit(), attrs[k].Emit())
+			}
+		}
+		if s.Status.Code != test.status {
+			t.Errorf("Wrong %s span status, expected %v; got %v", s.Name, test.status, s.Status.Code)
+		}
+	}
+}
+
+// tracedEngine is an engine that starts a span on its lock calls
+type tracedEngine struct {
+	*engine.Memory
+	t trace.Tracer
+}
+
+func (e *tracedEngine) Lock(ctx context.Context, key string, opts engine.LockOptions) (engine.Lease, error) {
+	ctx, s := e.t.Start(ctx, "engine.Lock")
+	defer s.End()
+	l, err := e.Memory.Lock(ctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedLease{Lease: l, t: e.t}, nil
+}
+
+// tracedLease is a lease that starts a span on its calls
+type tracedLease struct {
+	engine.Lease
+	t trace.Tracer
+}
+
+func (l *tracedLease) Extend(ctx context.Context, d time.Duration) error {
+	ctx, s := l.t.Start(ctx, "engine.Extend")
+	defer s.End()
+	return l.Lease.Extend(ctx, d)
+}
+
+func (l *tracedLease) Unlock(ctx context.Context) error {
+	ctx, s := l.t.Start(ctx, "engine.Unlock")
+	defer s.End()
+	return l.Lease.Unlock(ctx)
+}
+
+func TestTracerEngineSpans(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	defer tp.Shutdown(context.Background())
+
+	e := &tracedEngine{Memory: &engine.Memory{}, t: tp.Tracer("test")}
+	l := warlock.Warlock{Key: "key", Engine: e, Tracer: otel.NewTracer(tp.Tracer("test"))}
+	if err := l.Lock(context.Background()); err != nil {
+		t.Fatalf("Lock shouldn't return an error: %v", err)
+	}
+	if err := l.Extend(context.Background(), time.Minute); err != nil {
+		t.Fatalf("Extend shouldn't return an error: %v", err)
+	}
+	if err := l.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock shouldn't return an error: %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range exp.GetSpans().Snapshots() {
+		spans[s.Name()] = s
+	}
+	tests := []struct {
+		parent string
+		child  string
+	}{
+		{"warlock.Lock", "engine.Lock"},
+		{"warlock.Extend", "engine.Extend"},
+		{"warlock.Unlock", "engine.Unlock"},
+	}
+	for _, test := range tests {
+		p, c := spans[test.parent], spans[test.child]
+		if p == nil || c == nil {
+			t.Errorf("Spans %s and %s should be exported, they weren't", test.parent, test.child)
+			continue
+		}
+		if c.Parent().SpanID() != p.SpanContext().SpanID() {
+			t.Errorf("Span %s should be a child of %s, it wasn't", test.child, test.parent)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"time"
+)
+
+// Attribute names used on the spans
+const (
+	KeyAttr      = "warlock.key"
+	EngineAttr   = "warlock.engine"
+	OutcomeAttr  = "warlock.outcome"
+	WaitedAttr   = "warlock.waited_ms"
+	AttemptsAttr = "warlock.attempts"
+	TokenAttr    = "warlock.token"
+)
+
+// Attribute is a key value pair that describes a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64 returns an integer attribute
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Duration returns a duration attribute in milliseconds
+func Duration(key string, value time.Duration) Attribute {
+	return Attribute{Key: key, Value: int64(value / time.Millisecond)}
+}
+
+// Tracer creates spans, it's the hook used to plug the tracing systems
+type Tracer interface {
+	// Start starts a new span as a child of the span on the context (if any),
+	// returns the context with the new span set on it
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a traced operation
+type Span interface {
+	// SetAttributes sets attributes on the span
+	SetAttributes(attrs ...Attribute)
+
+	// RecordError marks the span as failed with an error
+	RecordError(err error)
+
+	// End finishes the span
+	End()
+}
+
+// Dummy is a tracer that doesn't trace anything, used as the default one
+var Dummy Tracer = &dummy{}
+
+type dummy struct{}
+
+func (d *dummy) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, d
+}
+func (d *dummy) SetAttributes(attrs ...Attribute) {}
+func (d *dummy) RecordError(err error)            {}
+func (d *dummy) End()                             {}